@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/ninjaki8/GoSynth/gosynth"
+)
+
+// catalogListing is the JSON output of the catalog command.
+type catalogListing struct {
+	Total    int               `json:"total"`
+	Count    int               `json:"count"`
+	Beatmaps []gosynth.Beatmap `json:"beatmaps,omitempty"`
+}
+
+// defineCatalogFlags registers the flags of the catalog command.
+func defineCatalogFlags(flags *flag.FlagSet, opts *options) {
+	flags.StringVar(&opts.query.Search, "search", "", "only list beatmaps matching this API text search")
+	flags.StringVar(&opts.query.Mapper, "mapper", "", "only list beatmaps by this mapper")
+	flags.StringVar(&opts.query.Sort, "sort", "", "API sort order, e.g. published_at,DESC")
+	flags.Var(&opts.difficulties, "difficulty", "only list beatmaps with this difficulty (repeatable, e.g. Expert)")
+	flags.Float64Var(&opts.minRating, "min-rating", 0, "only list beatmaps with a difficulty rated at least this many stars")
+	flags.Float64Var(&opts.maxRating, "max-rating", 0, "only list beatmaps with a difficulty rated at most this many stars (0 = no limit)")
+	flags.BoolVar(&opts.requireRating, "require-rating", false, "leave out beatmaps without star ratings")
+	flags.StringVar(&opts.since, "since", "", "only list beatmaps added after a date (2024-05-01) or a duration ago (7d, 48h)")
+	flags.Var(&opts.include, "include", "only list beatmaps whose filename matches this glob (repeatable, e.g. 'Pack*')")
+	flags.Var(&opts.exclude, "exclude", "leave out beatmaps whose filename matches this glob (repeatable; wins over --include)")
+	flags.BoolVar(&opts.countOnly, "count-only", false, "only print the number of beatmaps")
+	flags.BoolVar(&opts.jsonOutput, "json", false, "print the catalog as JSON on stdout; all other output goes to stderr")
+	flags.DurationVar(&opts.timeout, "timeout", gosynth.DefaultAPITimeout, "timeout for each API page request, e.g. 30s")
+	flags.IntVar(&opts.concurrency, "concurrency", 4, "number of pages fetched in parallel")
+	flags.IntVar(&opts.query.PageSize, "page-size", 0, fmt.Sprintf("beatmaps per API page, up to %d (0 = API default)", gosynth.MaxPageSize))
+	flags.Float64Var(&opts.rateLimit, "rate-limit", gosynth.DefaultRateLimit, "maximum requests per second to synthriderz.com (0 = unlimited)")
+	flags.BoolVar(&opts.quietPages, "quiet-pages", false, "don't show progress while fetching the catalog, only the summary")
+	defineServerFlags(flags, opts)
+	defineOutputFlags(flags, opts)
+}
+
+// runCatalog implements `gosynth catalog`: it fetches every page of the server catalog,
+// applies the filters and prints the beatmaps that pass, without touching a device.
+func runCatalog(ctx context.Context, opts *options, out *console) int {
+	if opts.timeout <= 0 {
+		slog.Error("--timeout must be a positive duration")
+		return exitFatal
+	}
+	if opts.concurrency < 1 {
+		slog.Error("--concurrency must be at least 1")
+		return exitFatal
+	}
+	if opts.rateLimit < 0 {
+		slog.Error("--rate-limit must not be negative")
+		return exitFatal
+	}
+	if opts.query.PageSize < 0 || opts.query.PageSize > gosynth.MaxPageSize {
+		slog.Error(fmt.Sprintf("--page-size must be between 0 and %d", gosynth.MaxPageSize))
+		return exitFatal
+	}
+	if opts.minRating < 0 || opts.maxRating < 0 || (opts.maxRating > 0 && opts.maxRating < opts.minRating) {
+		slog.Error("--min-rating and --max-rating must not be negative, and --max-rating not below --min-rating")
+		return exitFatal
+	}
+	if _, err := gosynth.FilterByName(nil, opts.include, opts.exclude); err != nil {
+		slog.Error(err.Error())
+		return exitFatal
+	}
+	var since time.Time
+	if opts.since != "" {
+		var err error
+		if since, err = parseSince(opts.since, time.Now()); err != nil || opts.since == "last" {
+			slog.Error(fmt.Sprintf("invalid --since %q: use a date like 2024-05-01 or a duration like 7d", opts.since))
+			return exitFatal
+		}
+	}
+	gosynth.SetAPITimeout(opts.timeout)
+	gosynth.SetRateLimit(opts.rateLimit)
+
+	firstPage, err := gosynth.FetchPage(ctx, 1, opts.query)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error fetching beatmaps: %v", err))
+		return exitFatal
+	}
+	cat := fetchCatalog(ctx, firstPage, opts, out)
+	if ctx.Err() != nil {
+		return exitCancelled
+	}
+	beatmaps := cat.wanted
+	if opts.since != "" {
+		filtered, ok := gosynth.FilterSince(beatmaps, since)
+		if !ok {
+			slog.Warn("the API didn't report when beatmaps were added; --since is ignored")
+		} else {
+			beatmaps = filtered
+		}
+	}
+
+	code := exitOK
+	if cat.incomplete {
+		code = exitFailures
+	}
+
+	if opts.jsonOutput {
+		listing := catalogListing{Total: cat.total, Count: len(beatmaps)}
+		if !opts.countOnly {
+			listing.Beatmaps = append([]gosynth.Beatmap{}, beatmaps...)
+		}
+		if err := writeJSON(listing); err != nil {
+			slog.Error(fmt.Sprintf("Failed to write JSON report: %v", err))
+			return exitFatal
+		}
+		return code
+	}
+
+	if opts.countOnly {
+		fmt.Println(len(beatmaps))
+		return code
+	}
+	for _, bm := range beatmaps {
+		line := bm.Filename + "\t" + bm.Describe()
+		if len(bm.Difficulties) > 0 {
+			line += "\t" + strings.Join(bm.Difficulties, ", ")
+		}
+		fmt.Println(line)
+	}
+	slog.Info(fmt.Sprintf("%d of %d beatmaps on the server", len(beatmaps), cat.total))
+	return code
+}
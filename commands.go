@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/ninjaki8/GoSynth/gosynth"
+)
+
+// command is a subcommand of the gosynth CLI.
+type command struct {
+	name    string
+	summary string
+	// define registers the command's flags, storing their values in opts
+	define func(flags *flag.FlagSet, opts *options)
+	// run executes the command once flags, config and logging are set up and returns
+	// the exit code
+	run func(ctx context.Context, opts *options, out *console) int
+}
+
+// Exit codes shared by all commands
+const (
+	// exitOK means everything worked (or the device is fully synced)
+	exitOK = 0
+	// exitFatal means a setup problem such as a missing adb or device stopped the run
+	exitFatal = 1
+	// exitFailures means some beatmaps failed to sync (or, with --dry-run, are missing)
+	exitFailures = 2
+	// exitCancelled means the run was interrupted
+	exitCancelled = 3
+	// exitDeadline means --deadline ran out before the sync finished
+	exitDeadline = 4
+)
+
+// commands lists the subcommands in the order usage shows them
+var commands = []*command{
+	{name: "sync", summary: "Push every beatmap the device is missing (the default command).", define: defineSyncFlags, run: runSync},
+	{name: "devices", summary: "List the devices adb can see and their state.", define: defineDevicesFlags, run: runDevices},
+	{name: "list", summary: "List the songs on a device without contacting synthriderz.com.", define: defineListFlags, run: runList},
+	{name: "prune", summary: "Delete beatmaps from a device that are no longer on the server.", define: definePruneFlags, run: runPrune},
+	{name: "catalog", summary: "List the beatmaps on the server, with the sync filters applied, without touching a device.", define: defineCatalogFlags, run: runCatalog},
+	{name: "verify", summary: "Compare the beatmaps on a device with the server's sizes and re-push broken ones.", define: defineVerifyFlags, run: runVerify},
+	{name: "doctor", summary: "Check that adb, synthriderz.com and the local folders work.", define: defineDoctorFlags, run: runDoctor},
+}
+
+// findCommand returns the command with the given name, or nil.
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// usage prints the available commands.
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: gosynth [command] [flags]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w, "\nEnvironment:")
+	fmt.Fprintln(w, "  ADB                        adb binary to run")
+	fmt.Fprintln(w, "  SYNTHRIDERZ_API            beatmap listing endpoint of a mirror")
+	fmt.Fprintln(w, "  SYNTHRIDERZ_DOWNLOAD_BASE  host the mirror's download paths are relative to")
+	fmt.Fprintln(w, "  SYNTHRIDERZ_API_TOKEN      token for authenticated API requests")
+	fmt.Fprintln(w, "  HTTP_PROXY, HTTPS_PROXY    proxy for requests, unless --proxy is given")
+	fmt.Fprintln(w, "  NO_PROXY                   hosts to reach without the proxy")
+	fmt.Fprintln(w, "\nRun 'gosynth <command> -h' for the flags of a command.")
+}
+
+// knownSetting reports whether any command has a flag with the given name.
+func knownSetting(name string) bool {
+	for _, cmd := range commands {
+		flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		cmd.define(flags, &options{})
+		if flags.Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// defineDeviceFlags registers the flags shared by commands that work on a device.
+func defineDeviceFlags(flags *flag.FlagSet, opts *options) {
+	flags.StringVar(&opts.serial, "serial", "", "serial of the device to use (skips the interactive prompt)")
+	flags.StringVar(&opts.transportID, "transport-id", "", "use the device with this adb transport id (see gosynth devices)")
+	flags.IntVar(&opts.deviceIndex, "device-index", 0, "use the Nth device, numbered like in the device prompt (skips the prompt)")
+	flags.StringVar(&opts.model, "model", "", "use the device of this model, e.g. \"Quest 3\" (prompts if several match)")
+	flags.StringVar(&opts.connect, "connect", "", "connect to a wireless ADB device at host:port first")
+	flags.StringVar(&opts.remoteDir, "remote-dir", gosynth.DefaultRemoteDir, "CustomSongs folder on the device")
+	flags.StringVar(&opts.adbPath, "adb-path", "", "adb binary to run (default $ADB, then adb on PATH)")
+	flags.DurationVar(&opts.deviceWait, "device-wait", gosynth.DefaultDeviceWait, "how long to wait for devices after starting the ADB server (0 = don't wait)")
+}
+
+// defineServerFlags registers the flags that point gosynth at a mirror of synthriderz.com.
+func defineServerFlags(flags *flag.FlagSet, opts *options) {
+	flags.StringVar(&opts.endpoint, "endpoint", "", "beatmap listing endpoint of a compatible mirror (default $SYNTHRIDERZ_API, then synthriderz.com)")
+	flags.StringVar(&opts.downloadBase, "download-base", "", "host the mirror's download paths are relative to (default $SYNTHRIDERZ_DOWNLOAD_BASE, then synthriderz.com)")
+	flags.StringVar(&opts.apiToken, "api-token", "", "token sent to the API and download hosts for higher rate limits or private beatmaps (default $SYNTHRIDERZ_API_TOKEN)")
+	flags.StringVar(&opts.userAgent, "user-agent", "", "User-Agent sent with every request (default identifies gosynth and its version)")
+	flags.StringVar(&opts.proxy, "proxy", "", "HTTP or SOCKS5 proxy for all requests, e.g. http://proxy:3128 (default $HTTPS_PROXY/$HTTP_PROXY)")
+}
+
+// defineOutputFlags registers the flags that control logging.
+func defineOutputFlags(flags *flag.FlagSet, opts *options) {
+	flags.BoolVar(&opts.verbose, "verbose", false, "show debug output")
+	flags.BoolVar(&opts.quiet, "quiet", false, "only show errors")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultConfigPath returns where the config file is looked up when --config isn't given
+// (e.g. ~/.config/gosynth/config.yaml).
+func defaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gosynth", "config.yaml"), nil
+}
+
+// readConfig parses a config file of flat YAML "key: value" lines, where each key is
+// the name of a command-line flag. Lists such as `difficulty: [Expert, Master]` set a
+// repeatable flag once per item. Blank lines and # comments, including ones after a
+// value, are ignored.
+func readConfig(path string) (map[string][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string][]string)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected \"key: value\"", path, lineNum)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(stripComment(value))
+
+		if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			for _, item := range strings.Split(strings.Trim(value, "[]"), ",") {
+				if item = unquote(strings.TrimSpace(item)); item != "" {
+					values[key] = append(values[key], item)
+				}
+			}
+			continue
+		}
+		values[key] = append(values[key], unquote(value))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// stripComment removes a trailing "# comment" from a config value. Like in YAML, the #
+// only starts a comment at the start of the value or after a space, and not inside quotes:
+// Song#1 and "Song #1" keep it.
+func stripComment(value string) string {
+	var quote byte
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || value[i-1] == ' ' || value[i-1] == '\t'):
+			return value[:i]
+		}
+	}
+	return value
+}
+
+// unquote strips matching single or double quotes around a config value.
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// loadConfig applies the config file at path, or the default config file if path is empty.
+func loadConfig(flags *flag.FlagSet, path string) error {
+	if path != "" {
+		return applyConfig(flags, path, true)
+	}
+	path, err := defaultConfigPath()
+	if err != nil {
+		return nil
+	}
+	return applyConfig(flags, path, false)
+}
+
+// applyConfig sets every flag that wasn't given on the command line from the config
+// file, so flags override the config and the config overrides the built-in defaults.
+// A missing file is only an error when it was asked for explicitly.
+func applyConfig(flags *flag.FlagSet, path string, explicit bool) error {
+	values, err := readConfig(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	setOnCommandLine := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+
+	for key, items := range values {
+		if flags.Lookup(key) == nil || key == "config" {
+			// The file is shared by every command; only reject settings none of them know
+			if key != "config" && knownSetting(key) {
+				continue
+			}
+			return fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+		if setOnCommandLine[key] {
+			continue
+		}
+		for _, item := range items {
+			if err := flags.Set(key, item); err != nil {
+				return fmt.Errorf("%s: invalid value %q for %s: %w", path, item, key, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/ninjaki8/GoSynth/gosynth"
+)
+
+// deviceFiles caches the listing of a device's songs folder for one run, so the phases
+// of a sync share a single `adb shell ls`. Changes made by the run itself are recorded
+// with add and remove; the folder is only listed again with refresh where the listing
+// may be stale, right before pruning and after the push phase.
+//
+// Archives pushed with --unpack never appear on the device themselves, so the files they
+// were extracted into are remembered in the device's manifest, and an archive whose files
+// are all there is listed as if it were.
+type deviceFiles struct {
+	adb    gosynth.ADBClient
+	serial string
+	opts   *options
+	files  []string
+	loaded bool
+	// unpacked maps the remote paths of unpacked archives to their extracted files
+	unpacked map[string][]string
+	// unpackedChanged is set when unpacked needs saving
+	unpackedChanged bool
+}
+
+func newDeviceFiles(adb gosynth.ADBClient, serial string, opts *options) *deviceFiles {
+	d := &deviceFiles{adb: adb, serial: serial, opts: opts, unpacked: make(map[string][]string)}
+	if manifestPath, err := gosynth.ManifestPath(serial); err == nil {
+		if manifest, err := gosynth.LoadManifest(manifestPath); err == nil && manifest.Unpacked != nil {
+			d.unpacked = manifest.Unpacked
+		}
+	}
+	return d
+}
+
+// get returns the cached listing, listing the folder on first use.
+func (d *deviceFiles) get(ctx context.Context) ([]string, error) {
+	if d.loaded {
+		return d.files, nil
+	}
+	return d.refresh(ctx)
+}
+
+// refresh lists the folder again, e.g. after something else changed it. With --recursive,
+// the beatmaps in subfolders are included too, by file name. On failure the cache is
+// left as it was.
+func (d *deviceFiles) refresh(ctx context.Context) ([]string, error) {
+	var files []string
+	var err error
+	if d.opts.recursive {
+		files, err = gosynth.ListDeviceSongsRecursive(ctx, d.adb, d.opts.remoteDir, d.serial)
+		for i, file := range files {
+			files[i] = path.Base(file)
+		}
+	} else {
+		files, err = gosynth.ListDeviceSongs(ctx, d.adb, d.opts.remoteDir, d.serial)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.files, d.loaded = append(files, d.presentArchives(files)...), true
+	return d.files, nil
+}
+
+// presentArchives returns the unpacked archives in the songs folder whose extracted files
+// are all among files.
+func (d *deviceFiles) presentArchives(files []string) []string {
+	listed := make(map[string]bool, len(files))
+	for _, file := range files {
+		listed[file] = true
+	}
+
+	var archives []string
+	for remotePath, extracted := range d.unpacked {
+		if path.Dir(remotePath) != path.Clean(d.opts.remoteDir) {
+			continue
+		}
+		present := true
+		for _, file := range extracted {
+			if !listed[d.listedName(file)] {
+				present = false
+				break
+			}
+		}
+		if present {
+			archives = append(archives, path.Base(remotePath))
+		}
+	}
+	slices.Sort(archives)
+	return archives
+}
+
+// listedName returns the name an extracted file shows up under in the listing: its top
+// folder, or with --recursive its file name.
+func (d *deviceFiles) listedName(file string) string {
+	if d.opts.recursive {
+		return path.Base(file)
+	}
+	top, _, _ := strings.Cut(file, "/")
+	return top
+}
+
+// extracted reports whether a listed file came from an unpacked archive in the songs folder.
+func (d *deviceFiles) extracted(filename string) bool {
+	for remotePath, extracted := range d.unpacked {
+		if path.Dir(remotePath) != path.Clean(d.opts.remoteDir) {
+			continue
+		}
+		for _, file := range extracted {
+			if d.listedName(file) == filename {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pushed records a beatmap pushed to the folder and returns the files it put there,
+// relative to the folder: the beatmap itself, or the files an archive was unpacked into.
+func (d *deviceFiles) pushed(result gosynth.BeatmapResult) []string {
+	d.add(result.Beatmap.Filename)
+	if len(result.Unpacked) == 0 {
+		return []string{result.Beatmap.Filename}
+	}
+
+	d.unpacked[path.Join(path.Clean(d.opts.remoteDir), result.Beatmap.Filename)] = result.Unpacked
+	d.unpackedChanged = true
+	for _, file := range result.Unpacked {
+		d.add(d.listedName(file))
+	}
+	return result.Unpacked
+}
+
+// add records a file pushed to the folder.
+func (d *deviceFiles) add(filename string) {
+	if !slices.Contains(d.files, filename) {
+		d.files = append(d.files, filename)
+	}
+}
+
+// remove records a file deleted from the folder.
+func (d *deviceFiles) remove(filename string) {
+	d.files = slices.DeleteFunc(d.files, func(file string) bool { return file == filename })
+}
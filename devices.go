@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/ninjaki8/GoSynth/gosynth"
+)
+
+// deviceInfo is one entry of the JSON output of the devices command.
+type deviceInfo struct {
+	Serial      string `json:"serial"`
+	Model       string `json:"model"`
+	State       string `json:"state"`
+	TransportID string `json:"transport_id,omitempty"`
+}
+
+// defineDevicesFlags registers the flags of the devices command.
+func defineDevicesFlags(flags *flag.FlagSet, opts *options) {
+	flags.StringVar(&opts.connect, "connect", "", "connect to a wireless ADB device at host:port first")
+	flags.StringVar(&opts.adbPath, "adb-path", "", "adb binary to run (default $ADB, then adb on PATH)")
+	flags.DurationVar(&opts.deviceWait, "device-wait", gosynth.DefaultDeviceWait, "how long to wait for devices after starting the ADB server (0 = don't wait)")
+	flags.BoolVar(&opts.jsonOutput, "json", false, "print the devices as JSON on stdout; all other output goes to stderr")
+	defineOutputFlags(flags, opts)
+}
+
+// runDevices implements `gosynth devices`: it lists every device adb can see, including
+// the ones that aren't ready, with a hint on how to fix them.
+func runDevices(ctx context.Context, opts *options, _ *console) int {
+	adb, err := newADB(opts)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitFatal
+	}
+	devices, err := startADB(ctx, adb, opts)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitFatal
+	}
+
+	if opts.jsonOutput {
+		infos := []deviceInfo{}
+		for _, device := range devices {
+			infos = append(infos, deviceInfo{Serial: device.Serial, Model: device.Model, State: device.State, TransportID: device.TransportID})
+		}
+		if err := writeJSON(infos); err != nil {
+			slog.Error(fmt.Sprintf("Failed to write JSON report: %v", err))
+			return exitFatal
+		}
+		return exitOK
+	}
+
+	if len(devices) == 0 {
+		slog.Info("No devices found.\n" + noDevicesHelp)
+		return exitOK
+	}
+	for _, device := range devices {
+		fmt.Printf("%s\t%s\t%s\t%s\n", device.Serial, device.State, device.Model, device.TransportID)
+		if !device.Ready() {
+			fmt.Printf("  %s\n", deviceStateHint(device.State))
+		}
+	}
+	return exitOK
+}
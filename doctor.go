@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ninjaki8/GoSynth/gosynth"
+)
+
+// doctorCheck is one item of the doctor checklist.
+type doctorCheck struct {
+	name string
+	// critical checks make doctor exit non-zero when they fail
+	critical bool
+	// run returns a short detail to show on success
+	run func(ctx context.Context) (string, error)
+}
+
+// defineDoctorFlags registers the flags of the doctor command.
+func defineDoctorFlags(flags *flag.FlagSet, opts *options) {
+	flags.StringVar(&opts.adbPath, "adb-path", "", "adb binary to run (default $ADB, then adb on PATH)")
+	flags.DurationVar(&opts.timeout, "timeout", gosynth.DefaultAPITimeout, "timeout for the synthriderz.com request, e.g. 30s")
+	defineServerFlags(flags, opts)
+	defineOutputFlags(flags, opts)
+}
+
+// runDoctor implements `gosynth doctor`: it checks the usual setup problems and prints
+// a checklist, exiting 1 if anything critical is broken.
+func runDoctor(ctx context.Context, opts *options, _ *console) int {
+	if opts.timeout > 0 {
+		gosynth.SetAPITimeout(opts.timeout)
+	}
+	checks := []doctorCheck{
+		{name: "adb is installed", critical: true, run: func(ctx context.Context) (string, error) {
+			path, err := gosynth.FindADB(opts.adbPath)
+			if err != nil {
+				return "", fmt.Errorf("%w\n%s", err, adbInstallHelp())
+			}
+			version, err := gosynth.ADBVersion(ctx, gosynth.NewADBClientWithPath(path))
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s, %s", path, version), nil
+		}},
+		{name: "ADB server is running", run: func(ctx context.Context) (string, error) {
+			if !gosynth.IsADBServerRunning() {
+				return "", fmt.Errorf("not running; gosynth starts it when needed")
+			}
+			return "", nil
+		}},
+		{name: "synthriderz.com is reachable", critical: true, run: func(ctx context.Context) (string, error) {
+			page, err := gosynth.FetchPage(ctx, 1, gosynth.Query{})
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d beatmaps", page.Total), nil
+		}},
+		{name: "download cache is writable", run: func(ctx context.Context) (string, error) {
+			dir, err := gosynth.DefaultCacheDir()
+			if err != nil {
+				return "", fmt.Errorf("%w; use --no-cache", err)
+			}
+			if err := checkWritable(dir); err != nil {
+				return "", fmt.Errorf("%w; use --no-cache", err)
+			}
+			return dir, nil
+		}},
+		{name: "temp directory is writable", critical: true, run: func(ctx context.Context) (string, error) {
+			dir := os.TempDir()
+			if err := checkWritable(dir); err != nil {
+				return "", fmt.Errorf("%w; use --temp-dir", err)
+			}
+			return dir, nil
+		}},
+	}
+
+	broken := false
+	for _, check := range checks {
+		detail, err := check.run(ctx)
+		switch {
+		case err == nil && detail != "":
+			fmt.Printf("✅ %s (%s)\n", check.name, detail)
+		case err == nil:
+			fmt.Printf("✅ %s\n", check.name)
+		case check.critical:
+			broken = true
+			fmt.Printf("❌ %s: %v\n", check.name, err)
+		default:
+			fmt.Printf("⚠️  %s: %v\n", check.name, err)
+		}
+	}
+
+	if broken {
+		return exitFatal
+	}
+	return exitOK
+}
+
+// checkWritable creates and removes a file in dir.
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".gosynth-probe-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
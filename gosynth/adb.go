@@ -0,0 +1,509 @@
+package gosynth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRemoteDir is where Synth Riders looks for custom songs on the headset
+const DefaultRemoteDir = "/sdcard/SynthRidersUC/CustomSongs/"
+
+// Device is a device reported by `adb devices`
+type Device struct {
+	Serial string
+	Model  string
+	// State is adb's connection state, e.g. "device", "unauthorized" or "offline"
+	State string
+	// TransportID identifies the connection for `adb -t`; empty if adb didn't report one
+	TransportID string
+}
+
+// StateDevice is the state of a device that is connected and authorized
+const StateDevice = "device"
+
+// Ready reports whether adb can talk to the device.
+func (d Device) Ready() bool {
+	return d.State == StateDevice
+}
+
+// ADBClient runs adb commands. Methods return adb's raw output so the parsing done by
+// the functions in this package can be exercised with canned output instead of a device.
+type ADBClient interface {
+	// Version runs `adb version`
+	Version(ctx context.Context) ([]byte, error)
+	// StartServer starts the ADB server
+	StartServer(ctx context.Context) ([]byte, error)
+	// Connect runs `adb connect` for a wireless device at addr (host:port)
+	Connect(ctx context.Context, addr string) ([]byte, error)
+	// Devices runs `adb devices -l`
+	Devices(ctx context.Context) ([]byte, error)
+	// ListDir runs `ls` on a folder of the device
+	ListDir(ctx context.Context, serial string, dir string) ([]byte, error)
+	// Shell runs a command on the device; each argument reaches it as is, even with
+	// spaces or quotes in it
+	Shell(ctx context.Context, serial string, args ...string) ([]byte, error)
+	// Push copies a local file to the device; the paths don't go through a shell
+	Push(ctx context.Context, serial string, localPath string, remotePath string) ([]byte, error)
+	// PushStream writes everything read from r to a file on the device
+	PushStream(ctx context.Context, serial string, r io.Reader, remotePath string) ([]byte, error)
+}
+
+// execADBClient implements ADBClient by running the adb binary.
+type execADBClient struct {
+	bin string
+
+	mu sync.Mutex
+	// transports maps serials to the transport id their commands use instead
+	transports map[string]string
+}
+
+// NewADBClient returns an ADBClient that runs the adb binary found on PATH.
+func NewADBClient() ADBClient {
+	return &execADBClient{bin: "adb"}
+}
+
+// NewADBClientWithPath returns an ADBClient that runs the given adb binary.
+func NewADBClientWithPath(bin string) ADBClient {
+	return &execADBClient{bin: bin}
+}
+
+// FindADB locates the adb binary: bin if it's set, otherwise the ADB environment
+// variable, otherwise adb on PATH. It fails if the binary doesn't exist or can't be run.
+func FindADB(bin string) (string, error) {
+	if bin == "" {
+		bin = os.Getenv("ADB")
+	}
+	if bin == "" {
+		bin = "adb"
+	}
+
+	path, err := exec.LookPath(bin)
+	if err == nil {
+		return path, nil
+	}
+
+	// Say why an explicitly configured file can't be used
+	if info, statErr := os.Stat(bin); statErr == nil {
+		if info.IsDir() {
+			return "", fmt.Errorf("%w: %s is a directory", ErrADBNotFound, bin)
+		}
+		return "", fmt.Errorf("%w: %s is not executable", ErrADBNotFound, bin)
+	}
+	return "", fmt.Errorf("%w: %s", ErrADBNotFound, bin)
+}
+
+func (c *execADBClient) command(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, c.bin, args...)
+}
+
+// target returns the arguments that address a device: `-t id` if the serial was pinned
+// to a transport, otherwise `-s serial`.
+func (c *execADBClient) target(serial string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id, ok := c.transports[serial]; ok {
+		return []string{"-t", id}
+	}
+	return []string{"-s", serial}
+}
+
+// PinTransport makes adb commands for serial address the device by its transport id,
+// which stays unambiguous if two connections report the same serial. It has no effect on
+// ADB clients not created by this package.
+func PinTransport(adb ADBClient, serial string, transportID string) {
+	c, ok := adb.(*execADBClient)
+	if !ok || transportID == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.transports == nil {
+		c.transports = make(map[string]string)
+	}
+	c.transports[serial] = transportID
+}
+
+func (c *execADBClient) Version(ctx context.Context) ([]byte, error) {
+	return c.command(ctx, "version").CombinedOutput()
+}
+
+func (c *execADBClient) StartServer(ctx context.Context) ([]byte, error) {
+	return c.command(ctx, "start-server").CombinedOutput()
+}
+
+func (c *execADBClient) Connect(ctx context.Context, addr string) ([]byte, error) {
+	return c.command(ctx, "connect", addr).CombinedOutput()
+}
+
+func (c *execADBClient) Devices(ctx context.Context) ([]byte, error) {
+	return c.command(ctx, "devices", "-l").Output()
+}
+
+func (c *execADBClient) ListDir(ctx context.Context, serial string, dir string) ([]byte, error) {
+	return c.command(ctx, append(c.target(serial), "shell", "ls", shellQuote(dir))...).Output()
+}
+
+// Shell quotes every argument, since adb joins them with spaces and hands the result to
+// the device's shell, which would split paths like "/sdcard/My Songs" apart.
+func (c *execADBClient) Shell(ctx context.Context, serial string, args ...string) ([]byte, error) {
+	shellArgs := append(c.target(serial), "shell")
+	for _, arg := range args {
+		shellArgs = append(shellArgs, shellQuote(arg))
+	}
+	return c.command(ctx, shellArgs...).CombinedOutput()
+}
+
+func (c *execADBClient) Push(ctx context.Context, serial string, localPath string, remotePath string) ([]byte, error) {
+	return c.command(ctx, append(c.target(serial), "push", localPath, remotePath)...).CombinedOutput()
+}
+
+// PushStream pipes r into the device. `adb push` can't read from stdin, so this runs
+// `cat` through `adb exec-in`, which needs a reasonably recent adb and device.
+func (c *execADBClient) PushStream(ctx context.Context, serial string, r io.Reader, remotePath string) ([]byte, error) {
+	cmd := c.command(ctx, append(c.target(serial), "exec-in", "cat > "+shellQuote(remotePath))...)
+	cmd.Stdin = r
+	return cmd.CombinedOutput()
+}
+
+// shellQuote quotes s for the device's shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// IsADBServerRunning reports whether an ADB server is listening on its default port.
+func IsADBServerRunning() bool {
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:5037", 1*time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// ADBVersion returns the version line reported by adb, e.g. "Android Debug Bridge version 1.0.41".
+func ADBVersion(ctx context.Context, adb ADBClient) (string, error) {
+	output, err := adb.Version(ctx)
+	if err != nil {
+		return "", fmt.Errorf("adb version failed: %w", err)
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	if !strings.Contains(line, "Android Debug Bridge") {
+		return "", fmt.Errorf("unexpected adb version output: %q", output)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// StartADBServer starts the ADB server in the background.
+func StartADBServer(ctx context.Context, adb ADBClient) {
+	output, err := adb.StartServer(ctx)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to start ADB server: %v", err))
+	}
+
+	slog.Debug(fmt.Sprintf("adb start-server output:\n%s", output))
+}
+
+// ConnectWirelessDevice runs `adb connect` for a device listening at addr (host:port)
+// and waits until it shows up in the device list.
+func ConnectWirelessDevice(ctx context.Context, adb ADBClient, addr string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	output, err := adb.Connect(ctx, addr)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out connecting to %s after %v", addr, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("adb connect %s failed: %w\nOutput: %s", addr, err, output)
+	}
+
+	// adb connect exits 0 even when it fails, so inspect its output
+	result := strings.TrimSpace(string(output))
+	switch {
+	case strings.Contains(result, "already connected"):
+		slog.Info(fmt.Sprintf("Device %s is already connected.", addr))
+	case strings.Contains(result, "connected to"):
+		slog.Info(fmt.Sprintf("Connected to %s.", addr))
+	default:
+		return fmt.Errorf("adb connect %s failed: %s", addr, result)
+	}
+
+	// The device may take a moment to appear in `adb devices`
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		devices, err := ListConnectedDevices(ctx, adb)
+		if err == nil {
+			for _, device := range devices {
+				if device.Serial == addr {
+					return nil
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %s to appear in the device list after %v: %w", addr, timeout, ErrDeviceNotFound)
+		case <-ticker.C:
+		}
+	}
+}
+
+// DefaultDeviceWait is how long to wait for devices to show up after starting the ADB
+// server by default
+const DefaultDeviceWait = 5 * time.Second
+
+// WaitForDevices lists the devices adb knows about, polling for up to timeout until at
+// least one appears. A freshly started ADB server takes a moment to enumerate USB devices.
+// If none appear in time, the empty list is returned without an error.
+func WaitForDevices(ctx context.Context, adb ADBClient, timeout time.Duration) ([]Device, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		devices, err := ListConnectedDevices(ctx, adb)
+		if err != nil || len(devices) > 0 {
+			return devices, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline.C:
+			return devices, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// ListConnectedDevices lists all devices adb knows about, including ones that aren't
+// ready to use yet (check Device.Ready).
+func ListConnectedDevices(ctx context.Context, adb ADBClient) ([]Device, error) {
+	output, err := adb.Devices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []Device
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "List of devices") || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		device := Device{Serial: fields[0], Model: "(unknown)", State: parseDeviceState(fields[1:])}
+		for _, field := range fields {
+			if model, ok := strings.CutPrefix(field, "model:"); ok {
+				device.Model = model
+			} else if id, ok := strings.CutPrefix(field, "transport_id:"); ok {
+				device.TransportID = id
+			}
+		}
+
+		devices = append(devices, device)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
+
+// parseDeviceState reads the state column of an `adb devices -l` line. Most states are a
+// single word, but "no permissions" is followed by an explanation in parentheses.
+func parseDeviceState(fields []string) string {
+	if len(fields) >= 2 && fields[0] == "no" && fields[1] == "permissions" {
+		return "no permissions"
+	}
+	return fields[0]
+}
+
+// CheckDeviceFolder verifies that a folder exists on the connected device.
+func CheckDeviceFolder(ctx context.Context, adb ADBClient, folderPath string, serial string) error {
+	output, err := adb.Shell(ctx, serial, "ls", "-d", folderPath)
+	if err != nil || strings.Contains(string(output), "No such file or directory") {
+		return fmt.Errorf("folder %s does not exist on device %s", folderPath, serial)
+	}
+	return nil
+}
+
+// MakeDeviceFolder creates a folder and any missing parents on the device.
+func MakeDeviceFolder(ctx context.Context, adb ADBClient, folderPath string, serial string) error {
+	output, err := adb.Shell(ctx, serial, "mkdir", "-p", folderPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s on device %s: %w\nOutput: %s", folderPath, serial, err, output)
+	}
+	return nil
+}
+
+// ListDeviceSongs lists the contents of a specified folder on the connected device. A
+// folder that doesn't exist yet is empty; an error means the listing itself failed.
+func ListDeviceSongs(ctx context.Context, adb ADBClient, folderPath string, serial string) ([]string, error) {
+	// Get the output of the adb command
+	output, err := adb.ListDir(ctx, serial, folderPath)
+	if err != nil {
+		// A folder that doesn't exist yet simply has no songs
+		if missingFolder(err, output) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list %s on device: %w", folderPath, err)
+	}
+
+	// Split the output into lines and store them in a slice
+	lines := strings.Split(string(output), "\n")
+
+	// Android's ls may end lines with \r\n, so trim each name and drop empty lines
+	var nonEmptyLines []string
+	for _, line := range lines {
+		if name := strings.TrimSpace(line); name != "" {
+			nonEmptyLines = append(nonEmptyLines, name)
+		}
+	}
+
+	// Return the slice of lines
+	return nonEmptyLines, nil
+}
+
+// missingFolder reports whether a failed listing failed only because the folder doesn't
+// exist. Anything else, such as adb or the device being unreachable, is a real error that
+// mustn't be mistaken for an empty folder.
+func missingFolder(err error, output []byte) bool {
+	if strings.Contains(string(output), "No such file or directory") {
+		return true
+	}
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && strings.Contains(string(exitErr.Stderr), "No such file or directory")
+}
+
+// ListDeviceSongsRecursive lists every file below a folder on the device, including the
+// ones in subfolders, as paths relative to the folder (e.g. "Pack/song.synth"). Like
+// ListDeviceSongs, a missing folder is empty.
+func ListDeviceSongsRecursive(ctx context.Context, adb ADBClient, folderPath string, serial string) ([]string, error) {
+	output, err := adb.Shell(ctx, serial, "find", folderPath, "-type", "f")
+	if err != nil {
+		// A folder that doesn't exist yet simply has no songs
+		if missingFolder(err, output) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list %s on device: %w\nOutput: %s", folderPath, err, output)
+	}
+
+	// find prints full paths, one per line; anything else is an error message mixed in
+	prefix := strings.TrimSuffix(folderPath, "/") + "/"
+	var files []string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if rel, ok := strings.CutPrefix(line, prefix); ok && rel != "" {
+			files = append(files, rel)
+		}
+	}
+	return files, nil
+}
+
+// DeviceFileChecksum returns the hex checksum of a file on the device, computed with
+// the same algorithm as the expected checksum (MD5, SHA-1 or SHA-256).
+func DeviceFileChecksum(ctx context.Context, adb ADBClient, serial string, remotePath string, expected string) (string, error) {
+	var tool string
+	switch len(expected) {
+	case md5.Size * 2:
+		tool = "md5sum"
+	case sha1.Size * 2:
+		tool = "sha1sum"
+	case sha256.Size * 2:
+		tool = "sha256sum"
+	default:
+		return "", fmt.Errorf("unrecognized checksum %q", expected)
+	}
+
+	output, err := adb.Shell(ctx, serial, tool, remotePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to checksum %s on device: %w", remotePath, err)
+	}
+	// The output is "<checksum>  <path>"
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 || len(fields[0]) != len(expected) {
+		return "", fmt.Errorf("unexpected %s output for %s: %q", tool, remotePath, output)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// DeviceFileSize returns the size in bytes of a file on the device.
+func DeviceFileSize(ctx context.Context, adb ADBClient, serial string, remotePath string) (int64, error) {
+	output, err := adb.Shell(ctx, serial, "stat", "-c", "%s", remotePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat %s on device: %w", remotePath, err)
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected stat output for %s: %q", remotePath, output)
+	}
+	return size, nil
+}
+
+// DeleteDeviceFile removes a single file from the device.
+func DeleteDeviceFile(ctx context.Context, adb ADBClient, serial string, remotePath string) error {
+	output, err := adb.Shell(ctx, serial, "rm", "-f", remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w\nOutput: %s", remotePath, err, output)
+	}
+	return nil
+}
+
+// FreeSpace returns how many bytes are available on the device's filesystem holding dir.
+func FreeSpace(ctx context.Context, adb ADBClient, serial string, dir string) (int64, error) {
+	output, err := adb.Shell(ctx, serial, "df", "-k", dir)
+	if err != nil {
+		return 0, fmt.Errorf("df %s failed: %w\nOutput: %s", dir, err, output)
+	}
+	return parseDFAvailable(string(output))
+}
+
+// parseDFAvailable reads the "Available" column from the output of `df -k`.
+func parseDFAvailable(output string) (int64, error) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) < 2 {
+		return 0, fmt.Errorf("unexpected df output: %q", output)
+	}
+
+	column := -1
+	for i, name := range strings.Fields(lines[0]) {
+		if name == "Available" || name == "Avail" {
+			column = i
+			break
+		}
+	}
+	fields := strings.Fields(lines[len(lines)-1])
+	if column < 0 || column >= len(fields) {
+		return 0, fmt.Errorf("unexpected df output: %q", output)
+	}
+
+	kb, err := strconv.ParseInt(fields[column], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected df output: %q", output)
+	}
+	return kb * 1024, nil
+}
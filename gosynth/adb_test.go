@@ -0,0 +1,300 @@
+package gosynth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeADB is an ADBClient that answers with canned output instead of running adb.
+type fakeADB struct {
+	devices    string
+	devicesErr error
+	listDir    string
+	listDirErr error
+	// shell answers Shell calls; nil answers every call with empty output
+	shell func(args []string) ([]byte, error)
+	// pushErr fails every push
+	pushErr error
+
+	mu sync.Mutex
+	// shellCalls records the arguments of every Shell call
+	shellCalls [][]string
+	// pushed maps the remote paths pushed to their contents
+	pushed map[string]string
+}
+
+func (f *fakeADB) Version(ctx context.Context) ([]byte, error) {
+	return []byte("Android Debug Bridge version 1.0.41\n"), nil
+}
+
+func (f *fakeADB) StartServer(ctx context.Context) ([]byte, error) {
+	return nil, nil
+}
+
+func (f *fakeADB) Connect(ctx context.Context, addr string) ([]byte, error) {
+	return []byte("connected to " + addr + "\n"), nil
+}
+
+func (f *fakeADB) Devices(ctx context.Context) ([]byte, error) {
+	return []byte(f.devices), f.devicesErr
+}
+
+func (f *fakeADB) ListDir(ctx context.Context, serial string, dir string) ([]byte, error) {
+	return []byte(f.listDir), f.listDirErr
+}
+
+func (f *fakeADB) Shell(ctx context.Context, serial string, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	f.shellCalls = append(f.shellCalls, args)
+	f.mu.Unlock()
+	if f.shell == nil {
+		return nil, nil
+	}
+	return f.shell(args)
+}
+
+func (f *fakeADB) Push(ctx context.Context, serial string, localPath string, remotePath string) ([]byte, error) {
+	if f.pushErr != nil {
+		return []byte("adb: error: failed to copy"), f.pushErr
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.pushed == nil {
+		f.pushed = make(map[string]string)
+	}
+	f.pushed[remotePath] = localPath
+	return nil, nil
+}
+
+func (f *fakeADB) PushStream(ctx context.Context, serial string, r io.Reader, remotePath string) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if f.pushErr != nil {
+		return []byte("adb: error: failed to copy"), f.pushErr
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.pushed == nil {
+		f.pushed = make(map[string]string)
+	}
+	f.pushed[remotePath] = string(data)
+	return nil, nil
+}
+
+func TestListConnectedDevices(t *testing.T) {
+	adb := &fakeADB{devices: "List of devices attached\n" +
+		"1WMHH000000000 device usb:1-1 product:hollywood model:Quest_2 device:hollywood transport_id:3\n" +
+		"\n"}
+
+	devices, err := ListConnectedDevices(context.Background(), adb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Device{{Serial: "1WMHH000000000", Model: "Quest_2", State: "device", TransportID: "3"}}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("got %+v, want %+v", devices, want)
+	}
+}
+
+func TestListConnectedDevicesError(t *testing.T) {
+	adb := &fakeADB{devicesErr: errors.New("adb exited")}
+
+	if _, err := ListConnectedDevices(context.Background(), adb); err == nil {
+		t.Error("expected an error when adb devices fails")
+	}
+}
+
+func TestListDeviceSongs(t *testing.T) {
+	adb := &fakeADB{listDir: "a.synth\nb.synth\n"}
+
+	songs, err := ListDeviceSongs(context.Background(), adb, DefaultRemoteDir, "serial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.synth", "b.synth"}
+	if !reflect.DeepEqual(songs, want) {
+		t.Errorf("got %q, want %q", songs, want)
+	}
+}
+
+func TestListDeviceSongsCRLF(t *testing.T) {
+	adb := &fakeADB{listDir: "a.synth\r\nb.synth\r\n\r\n"}
+
+	songs, err := ListDeviceSongs(context.Background(), adb, DefaultRemoteDir, "serial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.synth", "b.synth"}
+	if !reflect.DeepEqual(songs, want) {
+		t.Errorf("got %q, want %q", songs, want)
+	}
+}
+
+func TestListConnectedDevicesStates(t *testing.T) {
+	adb := &fakeADB{devices: "List of devices attached\n" +
+		"AAA device usb:1-1 product:eureka model:Quest_3 device:eureka transport_id:1\n" +
+		"BBB unauthorized usb:1-2 transport_id:2\n" +
+		"192.168.1.5:5555 offline transport_id:3\n" +
+		"CCC no permissions (missing udev rules? user is in the plugdev group); see [http://developer.android.com/tools/device.html] usb:1-3 transport_id:4\n"}
+
+	devices, err := ListConnectedDevices(context.Background(), adb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Device{
+		{Serial: "AAA", Model: "Quest_3", State: "device", TransportID: "1"},
+		{Serial: "BBB", Model: "(unknown)", State: "unauthorized", TransportID: "2"},
+		{Serial: "192.168.1.5:5555", Model: "(unknown)", State: "offline", TransportID: "3"},
+		{Serial: "CCC", Model: "(unknown)", State: "no permissions", TransportID: "4"},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Fatalf("got %+v, want %+v", devices, want)
+	}
+	for i, device := range devices {
+		if ready := device.Ready(); ready != (i == 0) {
+			t.Errorf("%s: Ready() = %t", device.Serial, ready)
+		}
+	}
+}
+
+func TestMakeDeviceFolder(t *testing.T) {
+	adb := &fakeADB{}
+	if err := MakeDeviceFolder(context.Background(), adb, "/sdcard/My Songs/", "serial"); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"mkdir", "-p", "/sdcard/My Songs/"}}
+	if !reflect.DeepEqual(adb.shellCalls, want) {
+		t.Errorf("got %q, want %q", adb.shellCalls, want)
+	}
+
+	adb = &fakeADB{shell: func(args []string) ([]byte, error) {
+		return []byte("mkdir: '/sdcard/X': Permission denied"), errors.New("exit status 1")
+	}}
+	if err := MakeDeviceFolder(context.Background(), adb, "/sdcard/X", "serial"); err == nil {
+		t.Error("expected an error when mkdir fails")
+	}
+}
+
+// remotePaths are device paths that a shell would split or unquote
+var remotePaths = []string{
+	"/sdcard/My Songs/song.synth",
+	"/sdcard/Songs/Don't Stop Me Now.synth",
+	`/sdcard/Songs/"Quoted" $HOME; rm -rf x.synth`,
+	"/sdcard/Songs/''.synth",
+}
+
+func TestShellQuote(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	for _, remotePath := range remotePaths {
+		output, err := exec.Command("sh", "-c", "printf %s "+shellQuote(remotePath)).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != remotePath {
+			t.Errorf("%q came out of the shell as %q", remotePath, output)
+		}
+	}
+}
+
+func TestExecADBClientShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	// The fake adb prints its arguments one per line, like adb hands them to the device
+	bin := filepath.Join(t.TempDir(), "adb")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\nfor arg; do printf '%s\\n' \"$arg\"; done\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	adb := NewADBClientWithPath(bin)
+
+	for _, remotePath := range remotePaths {
+		output, err := adb.Shell(context.Background(), "serial", "stat", "-c", "%s", remotePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+		want := []string{"-s", "serial", "shell", "'stat'", "'-c'", "'%s'", shellQuote(remotePath)}
+		if !reflect.DeepEqual(args, want) {
+			t.Errorf("got %q, want %q", args, want)
+		}
+	}
+}
+
+func TestListDeviceSongsRecursive(t *testing.T) {
+	adb := &fakeADB{shell: func(args []string) ([]byte, error) {
+		return []byte("/sdcard/Songs/a.synth\r\n" +
+			"/sdcard/Songs/Pack/b.synth\n" +
+			"/sdcard/Songs/Pack/Deeper/c d.synth\n" +
+			"find: '/sdcard/Songs/private': Permission denied\n" +
+			"\n"), nil
+	}}
+
+	files, err := ListDeviceSongsRecursive(context.Background(), adb, "/sdcard/Songs/", "serial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.synth", "Pack/b.synth", "Pack/Deeper/c d.synth"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got %q, want %q", files, want)
+	}
+	if call := adb.shellCalls[0]; !reflect.DeepEqual(call, []string{"find", "/sdcard/Songs/", "-type", "f"}) {
+		t.Errorf("ran %q", call)
+	}
+}
+
+func TestListDeviceSongsMissingFolder(t *testing.T) {
+	adb := &fakeADB{
+		listDir:    "ls: /sdcard/SynthRidersUC/CustomSongs/: No such file or directory\n",
+		listDirErr: errors.New("exit status 1"),
+	}
+
+	songs, err := ListDeviceSongs(context.Background(), adb, DefaultRemoteDir, "serial")
+	if err != nil || len(songs) != 0 {
+		t.Errorf("got %q, %v; want an empty folder", songs, err)
+	}
+}
+
+func TestListDeviceSongsError(t *testing.T) {
+	adb := &fakeADB{
+		listDir:    "adb: device offline\n",
+		listDirErr: errors.New("exit status 1"),
+	}
+
+	if songs, err := ListDeviceSongs(context.Background(), adb, DefaultRemoteDir, "serial"); err == nil {
+		t.Errorf("got %q; want an error rather than an empty folder", songs)
+	}
+}
+
+func TestListDeviceSongsRecursiveMissingFolder(t *testing.T) {
+	adb := &fakeADB{shell: func(args []string) ([]byte, error) {
+		return []byte("find: '/sdcard/Songs': No such file or directory\n"), errors.New("exit status 1")
+	}}
+
+	files, err := ListDeviceSongsRecursive(context.Background(), adb, "/sdcard/Songs", "serial")
+	if err != nil || len(files) != 0 {
+		t.Errorf("got %q, %v; want an empty folder", files, err)
+	}
+}
+
+func TestListDeviceSongsRecursiveError(t *testing.T) {
+	adb := &fakeADB{shell: func(args []string) ([]byte, error) {
+		return []byte("error: no devices/emulators found\n"), errors.New("exit status 1")
+	}}
+
+	if files, err := ListDeviceSongsRecursive(context.Background(), adb, "/sdcard/Songs", "serial"); err == nil {
+		t.Errorf("got %q; want an error rather than an empty folder", files)
+	}
+}
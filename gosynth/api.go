@@ -0,0 +1,348 @@
+package gosynth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Beatmap represents a single beatmap entry in the API response
+type Beatmap struct {
+	Filename    string `json:"filename"`
+	DownloadUrl string `json:"download_url"`
+	// Difficulties lists the charted difficulties (e.g. "Expert"); nil if the API omitted them
+	Difficulties []string `json:"difficulties"`
+	// DifficultyRatings maps difficulties to their star rating; nil if the API omitted them
+	DifficultyRatings map[string]float64 `json:"difficulty_ratings"`
+	// Hash is the hex checksum of the beatmap file, if the API provides one
+	Hash string `json:"hash"`
+	// Title, Artist and Mapper describe the song; any of them may be empty
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	Mapper string `json:"mapper"`
+	// Rating is the community rating; nil if the API omitted it
+	Rating *float64 `json:"rating"`
+	// PublishedAt and UpdatedAt are RFC 3339 timestamps; either may be empty
+	PublishedAt string `json:"published_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// AddedAt returns when the beatmap was last published or updated, and false if the API
+// didn't provide a usable timestamp.
+func (b Beatmap) AddedAt() (time.Time, bool) {
+	var latest time.Time
+	for _, value := range []string{b.PublishedAt, b.UpdatedAt} {
+		if t, err := time.Parse(time.RFC3339, value); err == nil && t.After(latest) {
+			latest = t
+		}
+	}
+	return latest, !latest.IsZero()
+}
+
+// Describe returns a human-readable name such as "Song by Artist, charted by Mapper",
+// falling back to the filename when the API didn't provide a title.
+func (b Beatmap) Describe() string {
+	if b.Title == "" {
+		return b.Filename
+	}
+
+	desc := b.Title
+	if b.Artist != "" {
+		desc += " by " + b.Artist
+	}
+	if b.Mapper != "" {
+		desc += ", charted by " + b.Mapper
+	}
+	if b.Rating != nil {
+		desc += fmt.Sprintf(" (rated %.1f)", *b.Rating)
+	}
+	return desc
+}
+
+// BeatmapPage represents a single paginated response from the API
+type BeatmapPage struct {
+	Data      []Beatmap `json:"data"`
+	Count     int       `json:"count"`
+	Total     int       `json:"total"`
+	Page      int       `json:"page"`
+	PageCount int       `json:"pageCount"`
+}
+
+// APIEndpoint is the synthriderz.com beatmap listing endpoint
+const APIEndpoint = "https://synthriderz.com/api/beatmaps"
+
+// DownloadBase is the synthriderz.com host the API's download paths are relative to
+const DownloadBase = "https://synthriderz.com"
+
+// ServerConfig holds the URLs beatmaps are listed and downloaded from.
+type ServerConfig struct {
+	// APIBaseURL is the beatmap listing endpoint
+	APIBaseURL string
+	// DownloadBaseURL is prepended to the download paths returned by the API
+	DownloadBaseURL string
+	// APIToken is sent as a bearer token to the API and download hosts, e.g. for higher
+	// rate limits or private beatmaps; empty sends no Authorization header
+	APIToken string
+	// Proxy is the HTTP or SOCKS5 proxy all requests go through; nil uses the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+	Proxy *url.URL
+}
+
+// server is the configuration used by all API and download requests
+var server = ServerConfig{APIBaseURL: APIEndpoint, DownloadBaseURL: DownloadBase}
+
+// SetServerConfig changes the URLs beatmaps are listed and downloaded from, e.g. to use
+// a mirror. Empty fields keep the synthriderz.com defaults.
+func SetServerConfig(cfg ServerConfig) {
+	if cfg.APIBaseURL == "" {
+		cfg.APIBaseURL = APIEndpoint
+	}
+	if cfg.DownloadBaseURL == "" {
+		cfg.DownloadBaseURL = DownloadBase
+	}
+	server = cfg
+}
+
+// DefaultAPITimeout is the default timeout for a single API page request
+const DefaultAPITimeout = 10 * time.Second
+
+// Reusable HTTP client with timeout
+var client = &http.Client{Timeout: DefaultAPITimeout, Transport: newTransport()}
+
+// newTransport returns a transport like http.DefaultTransport that sends requests
+// through the configured proxy.
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxyFor
+	return transport
+}
+
+// proxyFor returns the proxy for req: the configured one if set, otherwise the one
+// from the environment.
+func proxyFor(req *http.Request) (*url.URL, error) {
+	if server.Proxy != nil {
+		return server.Proxy, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// SetAPITimeout changes the timeout for API page requests.
+func SetAPITimeout(timeout time.Duration) {
+	client.Timeout = timeout
+}
+
+// Query holds optional API query parameters. They are sent with every page so the
+// pagination stays consistent.
+type Query struct {
+	// Search is a free-text search
+	Search string
+	// Mapper only lists beatmaps charted by this mapper
+	Mapper string
+	// Sort orders the results (e.g. "published_at,DESC")
+	Sort string
+	// PageSize is the number of beatmaps per page, up to MaxPageSize; zero uses the API default
+	PageSize int
+}
+
+// MaxPageSize is the largest page size the API is asked for
+const MaxPageSize = 100
+
+// values encodes the query parameters for the given page.
+func (q Query) values(page int) url.Values {
+	v := url.Values{}
+	v.Set("page", strconv.Itoa(page))
+	if q.Search != "" {
+		v.Set("q", q.Search)
+	}
+	if q.Mapper != "" {
+		v.Set("mapper", q.Mapper)
+	}
+	if q.Sort != "" {
+		v.Set("sort", q.Sort)
+	}
+	if q.PageSize > 0 {
+		v.Set("limit", strconv.Itoa(q.PageSize))
+	}
+	return v
+}
+
+// FetchPage performs an HTTP GET request for a specific page number and returns the decoded BeatmapPage
+func FetchPage(ctx context.Context, page int, query Query) (BeatmapPage, error) {
+	url := server.APIBaseURL + "?" + query.values(page).Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return BeatmapPage{}, fmt.Errorf("failed to build request for page %d: %w", page, err)
+	}
+
+	resp, err := doRequest(client, req)
+	if err != nil {
+		return BeatmapPage{}, fmt.Errorf("request failed for page %d: %w", page, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return BeatmapPage{}, fmt.Errorf("request failed for page %d: %w", page, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status})
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return BeatmapPage{}, fmt.Errorf("request failed for page %d: %w", page, err)
+	}
+	apiResponse, err := decodePage(body)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("API response for page %d: %s", page, truncate(body, maxLoggedResponse)))
+		return BeatmapPage{}, fmt.Errorf("page %d: %w", page, err)
+	}
+
+	return apiResponse, nil
+}
+
+// maxLoggedResponse caps how much of a bad API response is logged
+const maxLoggedResponse = 4096
+
+// decodePage decodes an API page and checks that it has the fields pagination relies on,
+// so a changed API format fails clearly instead of looking like an empty catalog.
+func decodePage(body []byte) (BeatmapPage, error) {
+	var fields struct {
+		Data      *[]Beatmap `json:"data"`
+		Total     *int       `json:"total"`
+		PageCount *int       `json:"pageCount"`
+	}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return BeatmapPage{}, fmt.Errorf("%w: %v", ErrUnexpectedResponse, err)
+	}
+	switch {
+	case fields.Data == nil:
+		return BeatmapPage{}, fmt.Errorf("%w: no beatmap list", ErrUnexpectedResponse)
+	case fields.Total == nil || fields.PageCount == nil:
+		return BeatmapPage{}, fmt.Errorf("%w: no total or page count", ErrUnexpectedResponse)
+	case len(*fields.Data) > 0 && (*fields.Total == 0 || *fields.PageCount == 0):
+		return BeatmapPage{}, fmt.Errorf("%w: %d beatmaps listed, but a total of %d on %d pages",
+			ErrUnexpectedResponse, len(*fields.Data), *fields.Total, *fields.PageCount)
+	}
+
+	var page BeatmapPage
+	if err := json.Unmarshal(body, &page); err != nil {
+		return BeatmapPage{}, fmt.Errorf("%w: %v", ErrUnexpectedResponse, err)
+	}
+	return page, nil
+}
+
+// truncate shortens b to at most n bytes for logging.
+func truncate(b []byte, n int) string {
+	if len(b) <= n {
+		return string(b)
+	}
+	return string(b[:n]) + "..."
+}
+
+// DefaultPageWorkers is the default number of pages fetched concurrently
+const DefaultPageWorkers = 4
+
+// FetchAllPages reuses the already fetched first page and fetches pages 2..PageCount with
+// up to workers requests in flight. Pages that fail are skipped and their errors are
+// returned alongside the pages that succeeded, both in page order. If onPage isn't nil,
+// it is called with the number of pages done so far, the first page included, each time
+// a request finishes.
+func FetchAllPages(ctx context.Context, firstPage BeatmapPage, query Query, workers int, onPage func(done int)) ([]BeatmapPage, []error) {
+	type pageResult struct {
+		num  int
+		page BeatmapPage
+		err  error
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan pageResult, firstPage.PageCount)
+	sem := make(chan struct{}, workers)
+
+	for pageNum := 2; pageNum <= firstPage.PageCount; pageNum++ {
+		wg.Add(1)
+		page := pageNum // capture loop variable safely
+
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			p, err := FetchPage(ctx, page, query)
+			results <- pageResult{num: page, page: p, err: err}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Results arrive in completion order; slot them in by page number
+	ordered := make([]pageResult, max(firstPage.PageCount+1, 2))
+	ordered[1] = pageResult{num: 1, page: firstPage}
+	done := 1
+	for result := range results {
+		done++
+		if onPage != nil {
+			onPage(done)
+		}
+		ordered[result.num] = result
+	}
+
+	var allPages []BeatmapPage
+	var errs []error
+	for _, result := range ordered[1:] {
+		switch {
+		case result.err != nil:
+			errs = append(errs, result.err)
+		case result.num != 0:
+			allPages = append(allPages, result.page)
+		}
+	}
+	return allPages, errs
+}
+
+// FetchAllBeatmaps fetches every page of the catalog and returns the beatmaps from all
+// pages that could be fetched, along with the errors for pages that couldn't.
+func FetchAllBeatmaps(ctx context.Context, query Query) ([]Beatmap, []error) {
+	firstPage, err := FetchPage(ctx, 1, query)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	pages, errs := FetchAllPages(ctx, firstPage, query, DefaultPageWorkers, nil)
+	return CollectBeatmaps(pages), errs
+}
+
+// CollectBeatmaps flattens pages into a single list, keeping only the first beatmap seen
+// for each filename in case pages overlap because the catalog changed between fetches.
+// Filenames are compared like on the device, trimmed and case-insensitively. Beatmaps
+// with invalid filenames (see CheckFilename) are logged and left out.
+func CollectBeatmaps(pages []BeatmapPage) []Beatmap {
+	seen := make(map[string]bool)
+
+	var beatmaps []Beatmap
+	for _, page := range pages {
+		for _, beatmap := range page.Data {
+			if err := CheckFilename(beatmap.Filename); err != nil {
+				slog.Warn(fmt.Sprintf("skipping beatmap %q from page %d: %v", beatmap.Describe(), page.Page, err))
+				continue
+			}
+			key := normalizeFilename(beatmap.Filename)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			beatmaps = append(beatmaps, beatmap)
+		}
+	}
+	return beatmaps
+}
@@ -0,0 +1,262 @@
+package gosynth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestAPI serves the API from handler for the duration of the test.
+func newTestAPI(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	SetServerConfig(ServerConfig{APIBaseURL: srv.URL + "/api/beatmaps", DownloadBaseURL: srv.URL})
+	SetRateLimit(0)
+	t.Cleanup(func() {
+		srv.Close()
+		SetServerConfig(ServerConfig{})
+		SetRateLimit(DefaultRateLimit)
+	})
+	return srv
+}
+
+// servePages answers page requests with the given pages of beatmaps.
+func servePages(pages [][]Beatmap) http.HandlerFunc {
+	total := 0
+	for _, page := range pages {
+		total += len(page)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		num, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil || num < 1 || num > max(len(pages), 1) {
+			http.NotFound(w, r)
+			return
+		}
+		data := []Beatmap{}
+		if num <= len(pages) {
+			data = pages[num-1]
+		}
+		json.NewEncoder(w).Encode(BeatmapPage{Data: data, Count: len(data), Total: total, Page: num, PageCount: len(pages)})
+	}
+}
+
+func TestCollectBeatmapsOverlappingPages(t *testing.T) {
+	// A beatmap published between fetches pushes "b" from page 1 onto page 2 as well
+	pages := []BeatmapPage{
+		{Page: 1, Data: []Beatmap{{Filename: "a.synth"}, {Filename: "b.synth", Title: "first"}}},
+		{Page: 2, Data: []Beatmap{{Filename: "B.synth ", Title: "second"}, {Filename: "c.synth"}}},
+		{Page: 3, Data: []Beatmap{{Filename: "../evil.synth"}, {Filename: "d.synth"}}},
+	}
+
+	beatmaps := CollectBeatmaps(pages)
+	want := []string{"a.synth", "b.synth", "c.synth", "d.synth"}
+	if got := filenames(beatmaps); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if beatmaps[1].Title != "first" {
+		t.Errorf("kept %q, want the first copy", beatmaps[1].Title)
+	}
+}
+
+func TestFetchPage(t *testing.T) {
+	newTestAPI(t, servePages([][]Beatmap{{{Filename: "a.synth"}, {Filename: "b.synth"}}}))
+
+	page, err := FetchPage(context.Background(), 1, Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || page.PageCount != 1 || !reflect.DeepEqual(filenames(page.Data), []string{"a.synth", "b.synth"}) {
+		t.Errorf("unexpected page %+v", page)
+	}
+}
+
+func TestFetchAllPages(t *testing.T) {
+	newTestAPI(t, servePages([][]Beatmap{
+		{{Filename: "a.synth"}, {Filename: "b.synth"}},
+		{{Filename: "c.synth"}, {Filename: "d.synth"}},
+		{{Filename: "e.synth"}},
+	}))
+
+	firstPage, err := FetchPage(context.Background(), 1, Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, errs := FetchAllPages(context.Background(), firstPage, Query{}, 2, nil)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	want := []string{"a.synth", "b.synth", "c.synth", "d.synth", "e.synth"}
+	if got := filenames(CollectBeatmaps(pages)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFetchAllPagesEmpty(t *testing.T) {
+	newTestAPI(t, servePages(nil))
+
+	beatmaps, errs := FetchAllBeatmaps(context.Background(), Query{})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(beatmaps) != 0 {
+		t.Errorf("got %d beatmaps from an empty catalog", len(beatmaps))
+	}
+}
+
+func TestFetchPageDecodeError(t *testing.T) {
+	newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>Maintenance</html>"))
+	})
+
+	_, err := FetchPage(context.Background(), 1, Query{})
+	if !errors.Is(err, ErrUnexpectedResponse) {
+		t.Errorf("got %v, want ErrUnexpectedResponse", err)
+	}
+}
+
+func TestFetchPageStatusError(t *testing.T) {
+	newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	})
+
+	_, err := FetchPage(context.Background(), 1, Query{})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("got %v, want a status 500 error", err)
+	}
+}
+
+func TestFetchAllPagesFailedPage(t *testing.T) {
+	pages := servePages([][]Beatmap{{{Filename: "a.synth"}}, {{Filename: "b.synth"}}, {{Filename: "c.synth"}}})
+	newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		pages(w, r)
+	})
+
+	firstPage, err := FetchPage(context.Background(), 1, Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched, errs := FetchAllPages(context.Background(), firstPage, Query{}, 4, nil)
+	if len(errs) != 1 {
+		t.Fatalf("got errors %v, want one for page 2", errs)
+	}
+	if got := filenames(CollectBeatmaps(fetched)); !reflect.DeepEqual(got, []string{"a.synth", "c.synth"}) {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestFetchAllPagesOrder(t *testing.T) {
+	var pages [][]Beatmap
+	var want []string
+	for i := 1; i <= 6; i++ {
+		name := "song" + strconv.Itoa(i) + ".synth"
+		pages = append(pages, []Beatmap{{Filename: name}})
+		want = append(want, name)
+	}
+	serve := servePages(pages)
+	newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		// Answer the earlier pages last so they arrive out of order
+		num, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		time.Sleep(time.Duration(len(pages)-num) * 10 * time.Millisecond)
+		serve(w, r)
+	})
+
+	firstPage, err := FetchPage(context.Background(), 1, Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var done []int
+	fetched, errs := FetchAllPages(context.Background(), firstPage, Query{}, len(pages), func(n int) {
+		done = append(done, n)
+	})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	for i, page := range fetched {
+		if page.Page != i+1 {
+			t.Fatalf("page %d is at position %d", page.Page, i+1)
+		}
+	}
+	if got := filenames(CollectBeatmaps(fetched)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(done, []int{2, 3, 4, 5, 6}) {
+		t.Errorf("progress reported %v", done)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A proxy is asked for the absolute URL
+		proxied = append(proxied, r.URL.String())
+		servePages([][]Beatmap{{{Filename: "a.synth"}}})(w, r)
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetServerConfig(ServerConfig{APIBaseURL: "http://synthriderz.invalid/api/beatmaps", Proxy: proxyURL})
+	defer SetServerConfig(ServerConfig{})
+	SetRateLimit(0)
+	defer SetRateLimit(DefaultRateLimit)
+
+	// Both clients pick the proxy up from the server config
+	req := httptest.NewRequest(http.MethodGet, "http://synthriderz.invalid/", nil)
+	for name, c := range map[string]*http.Client{"API": client, "download": downloadClient} {
+		got, err := c.Transport.(*http.Transport).Proxy(req)
+		if err != nil || got == nil || got.String() != proxy.URL {
+			t.Errorf("%s client uses proxy %v, %v; want %s", name, got, err, proxy.URL)
+		}
+	}
+
+	if _, err := FetchPage(context.Background(), 1, Query{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(proxied) != 1 || proxied[0] != "http://synthriderz.invalid/api/beatmaps?page=1" {
+		t.Errorf("proxy saw %q", proxied)
+	}
+}
+
+func TestDecodePage(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		ok   bool
+	}{
+		{"valid", `{"data":[{"filename":"a.synth"}],"count":1,"total":1,"page":1,"pageCount":1}`, true},
+		{"empty catalog", `{"data":[],"count":0,"total":0,"page":1,"pageCount":0}`, true},
+		{"empty body", ``, false},
+		{"not JSON", `<html>Bad Gateway</html>`, false},
+		{"truncated", `{"data":[{"filename":"a.sy`, false},
+		{"empty object", `{}`, false},
+		{"null data", `{"data":null,"total":1,"pageCount":1}`, false},
+		{"renamed fields", `{"items":[{"filename":"a.synth"}],"total":1,"pages":1}`, false},
+		{"no page count", `{"data":[{"filename":"a.synth"}],"total":1}`, false},
+		{"beatmaps but no total", `{"data":[{"filename":"a.synth"}],"total":0,"pageCount":0}`, false},
+		{"wrong type", `{"data":"a.synth","total":1,"pageCount":1}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodePage([]byte(tt.body))
+			if tt.ok && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrUnexpectedResponse) {
+				t.Errorf("got %v, want ErrUnexpectedResponse", err)
+			}
+		})
+	}
+}
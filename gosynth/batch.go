@@ -0,0 +1,162 @@
+package gosynth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stagedBeatmap is a beatmap waiting in a staging folder to be pushed.
+type stagedBeatmap struct {
+	beatmap Beatmap
+	size    int64
+}
+
+// syncBatch downloads a batch of beatmaps into a staging folder with up to workers
+// concurrent downloads and pushes the folder with a single adb call. If that push fails,
+// or some files didn't arrive intact, those beatmaps are pushed one by one to find out
+// which ones are at fault. Downloads take their space from budget. Every beatmap's
+// outcome is passed to record.
+func syncBatch(ctx context.Context, batch []Beatmap, opts SyncOptions, workers int, budget *spaceBudget, record func(Beatmap, error)) {
+	stageDir, err := os.MkdirTemp(opts.TempDir, "gosynth-batch-*")
+	if err != nil {
+		for _, bm := range batch {
+			record(bm, fmt.Errorf("failed to create staging folder: %w", err))
+		}
+		return
+	}
+	defer os.RemoveAll(stageDir)
+
+	// Step 1: Download the batch into the staging folder
+	var mu sync.Mutex
+	var staged []stagedBeatmap
+	var wg sync.WaitGroup
+	jobs := make(chan Beatmap)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for bm := range jobs {
+				mapOpts := opts
+				mapOpts.space = budget.claim()
+				var size int64
+				err := limitMapTime(ctx, bm, opts.PerMapTimeout, func(ctx context.Context) error {
+					var err error
+					size, err = stageBeatmap(ctx, bm, mapOpts, stageDir)
+					return err
+				})
+				if err != nil {
+					mapOpts.space.release()
+					record(bm, err)
+					continue
+				}
+				mu.Lock()
+				staged = append(staged, stagedBeatmap{beatmap: bm, size: size})
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, bm := range batch {
+		jobs <- bm
+	}
+	close(jobs)
+	wg.Wait()
+
+	if len(staged) == 0 || ctx.Err() != nil {
+		for _, s := range staged {
+			record(s.beatmap, ctx.Err())
+		}
+		return
+	}
+
+	// Step 2: Push the whole folder at once, and let it finish for a while if the sync is
+	// cancelled meanwhile
+	pushCtx, cancel := graceContext(ctx, PushGracePeriod)
+	defer cancel()
+	lock := devicePushLock(opts.Serial)
+	lock.Lock()
+	start := time.Now()
+	_, pushErr := opts.ADB.Push(pushCtx, opts.Serial, stageDir+string(filepath.Separator)+".", opts.RemoteDir)
+	recordPush(time.Since(start))
+	lock.Unlock()
+
+	// Step 3: Check each file, falling back to a single push for the ones that didn't make it
+	for _, s := range staged {
+		name := s.beatmap.Filename
+		if pushErr == nil {
+			remoteSize, err := DeviceFileSize(pushCtx, opts.ADB, opts.Serial, path.Join(opts.RemoteDir, name))
+			if err == nil && remoteSize == s.size {
+				record(s.beatmap, nil)
+				continue
+			}
+		}
+		record(s.beatmap, pushBeatmap(ctx, opts.ADB, opts.Serial, filepath.Join(stageDir, name), opts.RemoteDir, name, s.size))
+	}
+}
+
+// stageBeatmap puts a beatmap into stageDir and returns its size. With a cache, the
+// cached copy is reused or downloaded first and then linked into the staging folder.
+func stageBeatmap(ctx context.Context, b Beatmap, opts SyncOptions, stageDir string) (int64, error) {
+	timeout := opts.DownloadTimeout
+	if timeout <= 0 {
+		timeout = DefaultDownloadTimeout
+	}
+	dlCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	fullURL := downloadURL(b)
+	stagedPath, err := localBeatmapPath(stageDir, b.Filename)
+	if err != nil {
+		return 0, err
+	}
+
+	if opts.CacheDir == "" {
+		size, err := downloadBeatmap(dlCtx, fullURL, stagedPath, b.Hash, opts.MaxDownloadSize, opts.space, opts.DownloadProgress)
+		if err != nil {
+			return 0, fmt.Errorf("failed to download %s: %w", b.Filename, err)
+		}
+		return size, nil
+	}
+
+	cachedPath, err := localBeatmapPath(opts.CacheDir, b.Filename)
+	if err != nil {
+		return 0, err
+	}
+	size, err := fetchToCache(dlCtx, fullURL, cachedPath, b, opts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download %s: %w", b.Filename, err)
+	}
+	if err := linkOrCopy(cachedPath, stagedPath); err != nil {
+		return 0, fmt.Errorf("failed to stage %s: %w", b.Filename, err)
+	}
+	return size, nil
+}
+
+// linkOrCopy hard-links src to dst, or copies it if they're on different filesystems.
+func linkOrCopy(src string, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
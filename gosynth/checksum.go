@@ -0,0 +1,60 @@
+package gosynth
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// newChecksum returns a hash matching the algorithm implied by the length of the
+// hex-encoded expected checksum (MD5, SHA-1 or SHA-256).
+func newChecksum(expected string) (hash.Hash, error) {
+	switch len(expected) {
+	case md5.Size * 2:
+		return md5.New(), nil
+	case sha1.Size * 2:
+		return sha1.New(), nil
+	case sha256.Size * 2:
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unrecognized checksum %q", expected)
+}
+
+// checksumMatches reports whether the hash's digest equals the expected hex checksum.
+func checksumMatches(h hash.Hash, expected string) bool {
+	return hex.EncodeToString(h.Sum(nil)) == strings.ToLower(expected)
+}
+
+// verifyFileChecksum checks a local file against the expected hex checksum.
+func verifyFileChecksum(filePath string, expected string) error {
+	h, err := newChecksum(expected)
+	if err != nil {
+		return err
+	}
+
+	if err := hashFile(h, filePath); err != nil {
+		return err
+	}
+	if !checksumMatches(h, expected) {
+		return fmt.Errorf("checksum mismatch for %s", filePath)
+	}
+	return nil
+}
+
+// hashFile feeds the contents of a local file into h.
+func hashFile(h hash.Hash, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(h, f)
+	return err
+}
@@ -0,0 +1,173 @@
+package gosynth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BeatmapExt is the file extension of Synth Riders beatmaps
+const BeatmapExt = ".synth"
+
+// normalizeFilename makes filenames comparable regardless of surrounding whitespace or case.
+func normalizeFilename(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// CheckFilename returns an error wrapping ErrInvalidFilename unless name is a plain,
+// non-empty file name that is safe to use in local and device paths.
+func CheckFilename(name string) error {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return fmt.Errorf("%w: empty", ErrInvalidFilename)
+	case name == "." || name == "..":
+		return fmt.Errorf("%w %q", ErrInvalidFilename, name)
+	case strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("%w %q: contains a path separator", ErrInvalidFilename, name)
+	case strings.ContainsFunc(name, unicode.IsControl):
+		return fmt.Errorf("%w %q: contains control characters", ErrInvalidFilename, name)
+	}
+	return nil
+}
+
+// localBeatmapPath returns the path of a beatmap file in a local folder. The filename is
+// reduced to its base name and checked, so the result can't point outside dir.
+func localBeatmapPath(dir string, filename string) (string, error) {
+	if err := CheckFilename(filename); err != nil {
+		return "", err
+	}
+	p := filepath.Join(dir, filepath.Base(filename))
+	if filepath.Dir(p) != filepath.Clean(dir) {
+		return "", fmt.Errorf("%w %q: escapes %s", ErrInvalidFilename, filename, dir)
+	}
+	return p, nil
+}
+
+// remoteBeatmapPath is localBeatmapPath for a folder on the device.
+func remoteBeatmapPath(dir string, filename string) (string, error) {
+	if err := CheckFilename(filename); err != nil {
+		return "", err
+	}
+	p := path.Join(dir, path.Base(filename))
+	if path.Dir(p) != path.Clean(dir) {
+		return "", fmt.Errorf("%w %q: escapes %s", ErrInvalidFilename, filename, dir)
+	}
+	return p, nil
+}
+
+// FindMissing returns the server beatmaps whose filenames aren't among deviceFiles,
+// sorted by filename. Names are compared trimmed and case-insensitively.
+func FindMissing(serverBeatmaps []Beatmap, deviceFiles []string) []Beatmap {
+	onDevice := make(map[string]bool, len(deviceFiles))
+	for _, file := range deviceFiles {
+		onDevice[normalizeFilename(file)] = true
+	}
+
+	var missing []Beatmap
+	for _, beatmap := range serverBeatmaps {
+		if !onDevice[normalizeFilename(beatmap.Filename)] {
+			missing = append(missing, beatmap)
+		}
+	}
+
+	sort.SliceStable(missing, func(i, j int) bool {
+		return missing[i].Filename < missing[j].Filename
+	})
+	return missing
+}
+
+// FindPresent returns the server beatmaps whose filenames are among deviceFiles, sorted
+// by filename. It is the complement of FindMissing.
+func FindPresent(serverBeatmaps []Beatmap, deviceFiles []string) []Beatmap {
+	onDevice := make(map[string]bool, len(deviceFiles))
+	for _, file := range deviceFiles {
+		onDevice[normalizeFilename(file)] = true
+	}
+
+	var present []Beatmap
+	for _, beatmap := range serverBeatmaps {
+		if onDevice[normalizeFilename(beatmap.Filename)] {
+			present = append(present, beatmap)
+		}
+	}
+
+	sort.SliceStable(present, func(i, j int) bool {
+		return present[i].Filename < present[j].Filename
+	})
+	return present
+}
+
+// FindChanged checksums the given beatmaps on the device and returns the ones whose
+// content differs from the server's, e.g. because the chart was updated under the same
+// filename. Beatmaps without a server checksum, or whose checksum couldn't be computed on
+// the device, can only be compared by name; they are counted as unchecked.
+func FindChanged(ctx context.Context, adb ADBClient, serial string, remoteDir string, beatmaps []Beatmap) (changed []Beatmap, unchecked int) {
+	for _, beatmap := range beatmaps {
+		if ctx.Err() != nil {
+			break
+		}
+		if beatmap.Hash == "" {
+			unchecked++
+			continue
+		}
+		remotePath, err := remoteBeatmapPath(remoteDir, beatmap.Filename)
+		if err != nil {
+			unchecked++
+			continue
+		}
+		sum, err := DeviceFileChecksum(ctx, adb, serial, remotePath, beatmap.Hash)
+		if err != nil {
+			slog.Debug(fmt.Sprintf("comparing %s by name only: %v", beatmap.Filename, err))
+			unchecked++
+			continue
+		}
+		if sum != strings.ToLower(beatmap.Hash) {
+			changed = append(changed, beatmap)
+		}
+	}
+	return changed, unchecked
+}
+
+// FindExtra returns the device files that look like beatmaps but aren't in the server
+// catalog, sorted by name. Anything without the beatmap extension (e.g. subdirectories)
+// is left alone.
+func FindExtra(serverBeatmaps []Beatmap, deviceFiles []string) []string {
+	onServer := make(map[string]bool, len(serverBeatmaps))
+	for _, beatmap := range serverBeatmaps {
+		onServer[normalizeFilename(beatmap.Filename)] = true
+	}
+
+	var extra []string
+	for _, file := range deviceFiles {
+		name := normalizeFilename(file)
+		if strings.HasSuffix(name, BeatmapExt) && !onServer[name] {
+			extra = append(extra, strings.TrimSpace(file))
+		}
+	}
+
+	sort.Strings(extra)
+	return extra
+}
+
+// ListLocalSongs lists the files in a local folder, for comparing against the server
+// like the contents of a device's songs folder.
+func ListLocalSongs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
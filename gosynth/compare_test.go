@@ -0,0 +1,46 @@
+package gosynth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCheckFilename(t *testing.T) {
+	valid := []string{"song.synth", "Don't Stop Me Now.synth", "Ünïcödé ♪.synth", "pack.zip", "..synth"}
+	for _, name := range valid {
+		if err := CheckFilename(name); err != nil {
+			t.Errorf("CheckFilename(%q) = %v", name, err)
+		}
+	}
+
+	invalid := []string{"", "   ", ".", "..", "../song.synth", "Pack/song.synth", `..\song.synth`, "/sdcard/song.synth", "song\n.synth", "song\x00.synth"}
+	for _, name := range invalid {
+		if err := CheckFilename(name); !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("CheckFilename(%q) = %v, want ErrInvalidFilename", name, err)
+		}
+	}
+}
+
+func TestSyncRejectsInvalidFilenames(t *testing.T) {
+	beatmaps := []Beatmap{{Filename: ""}, {Filename: "../../evil.synth"}, {Filename: "a/b.synth"}}
+
+	var results []BeatmapResult
+	adb := &fakeADB{}
+	succeeded, failed := Sync(context.Background(), beatmaps, SyncOptions{
+		ADB:      adb,
+		Serial:   "serial",
+		OnResult: func(result BeatmapResult) { results = append(results, result) },
+	})
+	if succeeded != 0 || failed != len(beatmaps) {
+		t.Errorf("got %d succeeded and %d failed", succeeded, failed)
+	}
+	for _, result := range results {
+		if !errors.Is(result.Err, ErrInvalidFilename) {
+			t.Errorf("%q: got %v, want ErrInvalidFilename", result.Beatmap.Filename, result.Err)
+		}
+	}
+	if len(adb.pushed) > 0 || len(adb.shellCalls) > 0 {
+		t.Error("invalid beatmaps reached the device")
+	}
+}
@@ -0,0 +1,40 @@
+package gosynth
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrDeviceNotFound means a device isn't connected or doesn't show up in `adb devices`
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrADBNotFound means the adb binary isn't installed or isn't where it was configured
+	ErrADBNotFound = errors.New("adb not found")
+	// ErrPushFailed means a beatmap couldn't be copied to the device intact
+	ErrPushFailed = errors.New("push failed")
+	// ErrNotBeatmap means the server answered a download with something else, such as an
+	// HTML error page
+	ErrNotBeatmap = errors.New("not a beatmap")
+	// ErrTooLarge means a beatmap is bigger than the configured maximum download size
+	ErrTooLarge = errors.New("beatmap too large")
+	// ErrNoSpace means a beatmap doesn't fit into the space left on the device
+	ErrNoSpace = errors.New("not enough space on the device")
+	// ErrInvalidFilename means the API listed a beatmap whose filename is empty or isn't a
+	// plain file name
+	ErrInvalidFilename = errors.New("invalid beatmap filename")
+	// ErrTimedOut means a beatmap took longer than the configured per-beatmap timeout
+	ErrTimedOut = errors.New("timed out")
+	// ErrUnexpectedResponse means the API answered with JSON that doesn't look like a
+	// beatmap page, e.g. because its format changed
+	ErrUnexpectedResponse = errors.New("unexpected API response")
+)
+
+// StatusError reports an HTTP response with an unexpected status code.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %s", e.Status)
+}
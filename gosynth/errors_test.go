@@ -0,0 +1,61 @@
+package gosynth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPushFailedError(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "song.synth")
+	if err := os.WriteFile(local, []byte("PK\x03\x04data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	adb := &fakeADB{pushErr: errors.New("exit status 1")}
+	err := pushBeatmap(context.Background(), adb, "serial", local, DefaultRemoteDir, "song.synth", 8)
+	if !errors.Is(err, ErrPushFailed) {
+		t.Errorf("failed push: got %v, want ErrPushFailed", err)
+	}
+
+	// A truncated file on the device is a failed push as well
+	adb = &fakeADB{shell: func(args []string) ([]byte, error) {
+		return []byte("4\n"), nil
+	}}
+	err = pushBeatmap(context.Background(), adb, "serial", local, DefaultRemoteDir, "song.synth", 8)
+	if !errors.Is(err, ErrPushFailed) {
+		t.Errorf("truncated push: got %v, want ErrPushFailed", err)
+	}
+	var mismatch *sizeMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Actual != 4 {
+		t.Errorf("truncated push: got %v, want a size mismatch", err)
+	}
+}
+
+func TestDeviceNotFoundError(t *testing.T) {
+	adb := &fakeADB{devices: "List of devices attached\n\n"}
+
+	err := ConnectWirelessDevice(context.Background(), adb, "192.168.1.5:5555", 100*time.Millisecond)
+	if !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("got %v, want ErrDeviceNotFound", err)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	})
+
+	_, err := FetchPage(context.Background(), 1, Query{})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("got %v, want a *StatusError", err)
+	}
+	if statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", statusErr.StatusCode, http.StatusServiceUnavailable)
+	}
+}
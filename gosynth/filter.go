@@ -0,0 +1,129 @@
+package gosynth
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// FilterByDifficulty keeps beatmaps offering at least one of the given difficulties
+// (compared case-insensitively). Beatmaps without difficulty data are kept. The second
+// result is false if no beatmap carried difficulty data, in which case nothing is filtered.
+func FilterByDifficulty(beatmaps []Beatmap, difficulties []string) ([]Beatmap, bool) {
+	if len(difficulties) == 0 {
+		return beatmaps, true
+	}
+
+	wanted := make(map[string]bool, len(difficulties))
+	for _, d := range difficulties {
+		wanted[strings.ToLower(strings.TrimSpace(d))] = true
+	}
+
+	hasData := false
+	var filtered []Beatmap
+	for _, beatmap := range beatmaps {
+		if beatmap.Difficulties == nil {
+			filtered = append(filtered, beatmap)
+			continue
+		}
+
+		hasData = true
+		for _, d := range beatmap.Difficulties {
+			if wanted[strings.ToLower(d)] {
+				filtered = append(filtered, beatmap)
+				break
+			}
+		}
+	}
+
+	if !hasData {
+		return beatmaps, false
+	}
+	return filtered, true
+}
+
+// FilterByRating keeps beatmaps with at least one difficulty whose star rating is between
+// min and max; a max of zero means no upper bound. Beatmaps without ratings are kept
+// unless requireRating is set. The second result is false if no beatmap carried ratings.
+func FilterByRating(beatmaps []Beatmap, min float64, max float64, requireRating bool) ([]Beatmap, bool) {
+	hasData := false
+	var filtered []Beatmap
+	for _, beatmap := range beatmaps {
+		if len(beatmap.DifficultyRatings) == 0 {
+			if !requireRating {
+				filtered = append(filtered, beatmap)
+			}
+			continue
+		}
+
+		hasData = true
+		for _, rating := range beatmap.DifficultyRatings {
+			if rating >= min && (max == 0 || rating <= max) {
+				filtered = append(filtered, beatmap)
+				break
+			}
+		}
+	}
+	return filtered, hasData
+}
+
+// FilterByName keeps beatmaps whose filename matches at least one include pattern (or
+// all beatmaps if there are none) and no exclude pattern, so excludes win over includes.
+// Patterns use path.Match syntax and, like filename comparisons with the device, ignore
+// case.
+func FilterByName(beatmaps []Beatmap, include []string, exclude []string) ([]Beatmap, error) {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	var filtered []Beatmap
+	for _, beatmap := range beatmaps {
+		name := normalizeFilename(beatmap.Filename)
+		if len(include) > 0 && !matchesAny(name, include) {
+			continue
+		}
+		if matchesAny(name, exclude) {
+			continue
+		}
+		filtered = append(filtered, beatmap)
+	}
+	return filtered, nil
+}
+
+// matchesAny reports whether the normalized name matches any of the patterns.
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(normalizeFilename(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterSince keeps beatmaps published or updated after since. Beatmaps without a
+// timestamp are kept. The second result is false if no beatmap carried a timestamp, in
+// which case nothing is filtered.
+func FilterSince(beatmaps []Beatmap, since time.Time) ([]Beatmap, bool) {
+	hasData := false
+	var filtered []Beatmap
+	for _, beatmap := range beatmaps {
+		added, ok := beatmap.AddedAt()
+		if !ok {
+			filtered = append(filtered, beatmap)
+			continue
+		}
+
+		hasData = true
+		if added.After(since) {
+			filtered = append(filtered, beatmap)
+		}
+	}
+
+	if !hasData {
+		return beatmaps, false
+	}
+	return filtered, true
+}
@@ -0,0 +1,80 @@
+package gosynth
+
+import (
+	"reflect"
+	"testing"
+)
+
+// filenames returns the filenames of beatmaps in order.
+func filenames(beatmaps []Beatmap) []string {
+	var names []string
+	for _, beatmap := range beatmaps {
+		names = append(names, beatmap.Filename)
+	}
+	return names
+}
+
+func TestFilterByName(t *testing.T) {
+	beatmaps := []Beatmap{
+		{Filename: "Daft Punk - One More Time.synth"},
+		{Filename: "Daft Punk - Around the World (Remix).synth"},
+		{Filename: "Queen - Bohemian Rhapsody.synth"},
+		{Filename: "queen - Don't Stop Me Now.synth"},
+	}
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{
+			name: "no patterns",
+			want: filenames(beatmaps),
+		},
+		{
+			name:    "include",
+			include: []string{"Daft Punk*"},
+			want:    []string{"Daft Punk - One More Time.synth", "Daft Punk - Around the World (Remix).synth"},
+		},
+		{
+			name:    "exclude wins over an overlapping include",
+			include: []string{"Daft Punk*"},
+			exclude: []string{"*remix*"},
+			want:    []string{"Daft Punk - One More Time.synth"},
+		},
+		{
+			name:    "exclude everything an include matches",
+			include: []string{"queen*"},
+			exclude: []string{"QUEEN*"},
+			want:    nil,
+		},
+		{
+			name:    "case-insensitive include",
+			include: []string{"QUEEN - *"},
+			want:    []string{"Queen - Bohemian Rhapsody.synth", "queen - Don't Stop Me Now.synth"},
+		},
+		{
+			name:    "case-insensitive exclude",
+			exclude: []string{"daft punk*"},
+			want:    []string{"Queen - Bohemian Rhapsody.synth", "queen - Don't Stop Me Now.synth"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filtered, err := FilterByName(beatmaps, tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := filenames(filtered); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterByNameInvalidPattern(t *testing.T) {
+	if _, err := FilterByName([]Beatmap{{Filename: "a.synth"}}, nil, []string{"[a-"}); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
@@ -0,0 +1,76 @@
+package gosynth
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// Manifest records the outcome of the last complete sync of a device, so a later run
+// can tell that nothing changed without comparing the whole catalog again.
+type Manifest struct {
+	Serial      string    `json:"serial"`
+	SyncedAt    time.Time `json:"synced_at"`
+	ServerTotal int       `json:"server_total"`
+	PageCount   int       `json:"page_count"`
+	// Filter describes the filters the sync ran with; a different filter needs a full run
+	Filter string   `json:"filter"`
+	Files  []string `json:"files"`
+	// Unpacked maps the remote paths of archives pushed with unpacking to the files they
+	// were extracted into, relative to the archive's folder. The archives never reach the
+	// device, so this is how later syncs tell they are there. It is kept up to date even
+	// by syncs that didn't complete, which leave SyncedAt zero if there was none before.
+	Unpacked map[string][]string `json:"unpacked,omitempty"`
+}
+
+// unsafeFileChars matches characters that can't appear in a manifest filename
+// (e.g. the colon in a wireless device's host:port serial)
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// ManifestPath returns where the manifest for a device serial is kept.
+func ManifestPath(serial string) (string, error) {
+	dir, err := DefaultCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "manifest-"+unsafeFileChars.ReplaceAllString(serial, "_")+".json"), nil
+}
+
+// LoadManifest reads a manifest written by Save.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Save writes the manifest to path, replacing it atomically.
+func (m *Manifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// UpToDate reports whether the manifest records a complete sync younger than ttl and the
+// server catalog, as described by its first page, hasn't changed size since.
+func (m *Manifest) UpToDate(firstPage BeatmapPage, ttl time.Duration) bool {
+	return !m.SyncedAt.IsZero() &&
+		time.Since(m.SyncedAt) < ttl &&
+		m.ServerTotal == firstPage.Total &&
+		m.PageCount == firstPage.PageCount
+}
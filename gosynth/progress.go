@@ -0,0 +1,56 @@
+package gosynth
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// progressInterval throttles how often download progress is reported
+const progressInterval = 500 * time.Millisecond
+
+// progressReader wraps a download body and periodically writes how many bytes have been
+// read so far, against the total when the server reported one.
+type progressReader struct {
+	r        io.Reader
+	name     string
+	total    int64
+	read     int64
+	out      io.Writer
+	lastShow time.Time
+}
+
+func newProgressReader(r io.Reader, name string, total int64, out io.Writer) *progressReader {
+	return &progressReader{r: r, name: name, total: total, out: out, lastShow: time.Now()}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+
+	if time.Since(p.lastShow) >= progressInterval {
+		p.lastShow = time.Now()
+		if p.total > 0 {
+			fmt.Fprintf(p.out, "⬇️  %s: %s / %s (%d%%)\n", p.name, FormatBytes(p.read), FormatBytes(p.total), p.read*100/p.total)
+		} else {
+			fmt.Fprintf(p.out, "⬇️  %s: %s\n", p.name, FormatBytes(p.read))
+		}
+	}
+
+	return n, err
+}
+
+// FormatBytes renders a byte count in human-readable units (e.g. "4.2 MB").
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,170 @@
+package gosynth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRateLimit is the default number of requests per second sent to synthriderz.com
+const DefaultRateLimit = 4
+
+// rateLimiter spaces requests out evenly so that no more than a set number start each
+// second, however many goroutines are making them.
+type rateLimiter struct {
+	mu sync.Mutex
+	// interval is the minimum time between two requests; zero disables the limit
+	interval time.Duration
+	// next is when the next request may start
+	next time.Time
+}
+
+// limiter is shared by all API and download requests
+var limiter = &rateLimiter{interval: time.Second / DefaultRateLimit}
+
+// SetRateLimit changes how many requests per second are sent to synthriderz.com.
+// Zero or less disables the limit.
+func SetRateLimit(perSecond float64) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	limiter.interval = 0
+	if perSecond > 0 {
+		limiter.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+}
+
+// Wait blocks until the caller may send a request or ctx is done.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	if l.interval <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(start.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// requestCount counts the HTTP requests sent, including retries
+var requestCount atomic.Int64
+
+// RequestCount returns how many HTTP requests were sent to synthriderz.com so far.
+func RequestCount() int64 {
+	return requestCount.Load()
+}
+
+// userAgent is sent with every request
+var userAgent = DefaultUserAgent()
+
+// DefaultUserAgent returns the User-Agent identifying gosynth and its version, e.g.
+// "GoSynth/v1.2.0 (+https://github.com/ninjaki8/GoSynth)".
+func DefaultUserAgent() string {
+	version := "dev"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	return "GoSynth/" + version + " (+https://github.com/ninjaki8/GoSynth)"
+}
+
+// SetUserAgent changes the User-Agent sent with every request. An empty string restores
+// the default.
+func SetUserAgent(ua string) {
+	if ua == "" {
+		ua = DefaultUserAgent()
+	}
+	userAgent = ua
+}
+
+// authorize adds the API token to requests for the configured API and download hosts.
+// Absolute download URLs pointing elsewhere, such as a CDN, never see the token.
+func authorize(req *http.Request) {
+	if server.APIToken == "" {
+		return
+	}
+	for _, base := range []string{server.APIBaseURL, server.DownloadBaseURL} {
+		if u, err := url.Parse(base); err == nil && u.Host == req.URL.Host {
+			req.Header.Set("Authorization", "Bearer "+server.APIToken)
+			return
+		}
+	}
+}
+
+// maxRetryWait caps how long doRequest waits in total on 429 responses before giving up
+const maxRetryWait = 2 * time.Minute
+
+// defaultRetryWait is used when a 429 response has no usable Retry-After header
+const defaultRetryWait = 5 * time.Second
+
+// doRequest sets the User-Agent and API token and sends req with c once the rate
+// limiter allows it. If the server answers 429 Too Many Requests, the request is
+// retried after the delay from its Retry-After header, until the waits add up to
+// maxRetryWait; then the 429 response is returned.
+func doRequest(c *http.Client, req *http.Request) (*http.Response, error) {
+	req.Header.Set("User-Agent", userAgent)
+	authorize(req)
+
+	var waited time.Duration
+	for {
+		if err := limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+		requestCount.Add(1)
+		resp, err := c.Do(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+			return resp, err
+		}
+
+		wait := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if waited+wait > maxRetryWait {
+			return resp, nil
+		}
+		resp.Body.Close()
+		waited += wait
+
+		slog.Warn(fmt.Sprintf("synthriderz.com is rate limiting us, retrying in %v", wait))
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// parseRetryAfter reads a Retry-After header, which holds either a number of seconds or
+// an HTTP date, and returns how long to wait from now.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(strings.TrimSpace(header)); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+	return defaultRetryWait
+}
@@ -0,0 +1,169 @@
+package gosynth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// CachedSize returns the size of the cached copy of a beatmap in cacheDir, if there is one.
+func CachedSize(b Beatmap, cacheDir string) (int64, bool) {
+	if cacheDir == "" {
+		return 0, false
+	}
+	cachedPath, err := localBeatmapPath(cacheDir, b.Filename)
+	if err != nil {
+		return 0, false
+	}
+	info, err := os.Stat(cachedPath)
+	if err != nil {
+		return 0, false
+	}
+	return info.Size(), true
+}
+
+// BeatmapSize returns the size of a beatmap's file: the size of the cached copy if there
+// is one in cacheDir, otherwise the Content-Length the server reports.
+func BeatmapSize(ctx context.Context, b Beatmap, cacheDir string) (int64, error) {
+	if size, ok := CachedSize(b, cacheDir); ok {
+		return size, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, downloadURL(b), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := doRequest(downloadClient, req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("server didn't report the size of %s", b.Filename)
+	}
+	return resp.ContentLength, nil
+}
+
+// tooLargeError reports a beatmap bigger than max bytes.
+func tooLargeError(max int64) error {
+	return fmt.Errorf("%w: over %s", ErrTooLarge, FormatBytes(max))
+}
+
+// cappedReader fails with ErrTooLarge once more than max bytes were read, for downloads
+// whose size the server didn't report.
+type cappedReader struct {
+	r   io.Reader
+	max int64
+	n   int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.n > c.max {
+		return n, tooLargeError(c.max)
+	}
+	return n, err
+}
+
+// spaceBudget tracks the free space on a device as downloads start, so that a sync
+// stops taking on beatmaps once they wouldn't fit. A nil budget allows everything.
+type spaceBudget struct {
+	mu   sync.Mutex
+	free int64
+	used int64
+}
+
+// newSpaceBudget returns a budget of free bytes, or nil if free isn't above zero.
+func newSpaceBudget(free int64) *spaceBudget {
+	if free <= 0 {
+		return nil
+	}
+	return &spaceBudget{free: free}
+}
+
+// claim starts a beatmap's share of the budget.
+func (b *spaceBudget) claim() *spaceClaim {
+	if b == nil {
+		return nil
+	}
+	return &spaceClaim{budget: b}
+}
+
+// spaceClaim is the space one beatmap takes from a spaceBudget; a nil claim allows
+// everything.
+type spaceClaim struct {
+	budget *spaceBudget
+	size   int64
+}
+
+// reserve takes size more bytes from the budget, or fails with ErrNoSpace if they don't
+// fit. Unknown sizes (-1) aren't checked.
+func (c *spaceClaim) reserve(size int64) error {
+	if c == nil || size <= 0 {
+		return nil
+	}
+	c.budget.mu.Lock()
+	defer c.budget.mu.Unlock()
+
+	if left := c.budget.free - c.budget.used; size > left {
+		return fmt.Errorf("%w: needs %s, %s left", ErrNoSpace, FormatBytes(size), FormatBytes(max(left, 0)))
+	}
+	c.budget.used += size
+	c.size += size
+	return nil
+}
+
+// release gives the reserved bytes back, for a beatmap that didn't make it to the device.
+func (c *spaceClaim) release() {
+	if c == nil {
+		return
+	}
+	c.budget.mu.Lock()
+	defer c.budget.mu.Unlock()
+
+	c.budget.used -= c.size
+	c.size = 0
+}
+
+// EstimateSizes looks up the size of each beatmap with up to workers concurrent requests.
+// The sizes are returned in the order of beatmaps, with -1 for those that couldn't be
+// determined.
+func EstimateSizes(ctx context.Context, beatmaps []Beatmap, cacheDir string, workers int) []int64 {
+	if workers < 1 {
+		workers = 1
+	}
+
+	sizes := make([]int64, len(beatmaps))
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				size, err := BeatmapSize(ctx, beatmaps[idx], cacheDir)
+				if err != nil {
+					size = -1
+				}
+				sizes[idx] = size
+			}
+		}()
+	}
+
+	for idx := range beatmaps {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	return sizes
+}
@@ -0,0 +1,59 @@
+package gosynth
+
+import (
+	"sync"
+	"time"
+)
+
+// TransferStats sums up the beatmap downloads and pushes made so far. Times of transfers
+// that ran concurrently add up, so they can exceed the wall-clock time. Streamed beatmaps
+// count as both a download and a push.
+type TransferStats struct {
+	// DownloadedBytes is the number of beatmap bytes received, not counting cache hits
+	DownloadedBytes int64
+	// StartedBytes adds up the sizes the server reported for the downloads started so
+	// far, which gives a running total without asking for every size up front
+	StartedBytes int64
+	// DownloadTime is the time spent downloading beatmaps
+	DownloadTime time.Duration
+	// PushTime is the time spent in adb pushes
+	PushTime time.Duration
+}
+
+var (
+	statsMu sync.Mutex
+	stats   TransferStats
+)
+
+// Stats returns the transfer statistics collected so far.
+func Stats() TransferStats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	return stats
+}
+
+// recordDownloadStart adds the reported size of a download that just started; unknown
+// sizes (-1) are left out.
+func recordDownloadStart(size int64) {
+	if size < 0 {
+		return
+	}
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	stats.StartedBytes += size
+}
+
+// recordDownload adds a finished download of n bytes that took d.
+func recordDownload(n int64, d time.Duration) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	stats.DownloadedBytes += n
+	stats.DownloadTime += d
+}
+
+// recordPush adds an adb push that took d.
+func recordPush(d time.Duration) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	stats.PushTime += d
+}
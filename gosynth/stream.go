@@ -0,0 +1,147 @@
+package gosynth
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// streamBeatmap pipes the download straight to the device without a local file. The
+// checksum and size are verified after the transfer, and a bad or partial copy is deleted
+// again. Since the download feeds the push, cancelling ctx only stops a started transfer
+// after PushGracePeriod.
+func streamBeatmap(ctx context.Context, url string, b Beatmap, opts SyncOptions) error {
+	timeout := opts.DownloadTimeout
+	if timeout <= 0 {
+		timeout = DefaultDownloadTimeout
+	}
+	streamCtx, cancel := graceContext(ctx, PushGracePeriod)
+	defer cancel()
+	streamCtx, cancelTimeout := context.WithTimeout(streamCtx, timeout)
+	defer cancelTimeout()
+
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := doRequest(downloadClient, req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", b.Filename, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed for %s: %w", b.Filename, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status})
+	}
+
+	body, err := checkBeatmapBody(resp)
+	if err != nil {
+		return fmt.Errorf("download failed for %s: %w", b.Filename, err)
+	}
+	recordDownloadStart(resp.ContentLength)
+	if opts.MaxDownloadSize > 0 {
+		if resp.ContentLength > opts.MaxDownloadSize {
+			return fmt.Errorf("download failed for %s: %w", b.Filename, tooLargeError(opts.MaxDownloadSize))
+		}
+		body = &cappedReader{r: body, max: opts.MaxDownloadSize}
+	}
+	if err := opts.space.reserve(resp.ContentLength); err != nil {
+		return fmt.Errorf("download failed for %s: %w", b.Filename, err)
+	}
+	if opts.DownloadProgress != nil {
+		body = newProgressReader(body, b.Filename, resp.ContentLength, opts.DownloadProgress)
+	}
+
+	var checksum hash.Hash
+	if b.Hash != "" {
+		if checksum, err = newChecksum(b.Hash); err == nil {
+			body = io.TeeReader(body, checksum)
+		}
+	}
+	counter := &countingReader{r: body}
+
+	remotePath, err := remoteBeatmapPath(opts.RemoteDir, b.Filename)
+	if err != nil {
+		return err
+	}
+
+	lock := devicePushLock(opts.Serial)
+	lock.Lock()
+	// Don't start a push after a shutdown was requested
+	if err := ctx.Err(); err != nil {
+		lock.Unlock()
+		return err
+	}
+	start := time.Now()
+	output, err := opts.ADB.PushStream(streamCtx, opts.Serial, counter, remotePath)
+	elapsed := time.Since(start)
+	recordPush(elapsed)
+	lock.Unlock()
+	if err != nil {
+		// Whatever arrived before the failure is a partial file
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), PushGracePeriod)
+		defer cancel()
+		if err := DeleteDeviceFile(cleanupCtx, opts.ADB, opts.Serial, remotePath); err != nil {
+			slog.Warn(err.Error())
+		}
+		return &streamError{err: fmt.Errorf("streaming %w: %w\nOutput: %s", ErrPushFailed, err, output)}
+	}
+
+	recordDownload(counter.n, elapsed)
+
+	// Verify what arrived; with no local copy to keep, a bad file is simply removed
+	var verifyErr error
+	switch {
+	case checksum != nil && !checksumMatches(checksum, b.Hash):
+		verifyErr = fmt.Errorf("checksum mismatch for %s: expected %s", b.Filename, b.Hash)
+	case checksum == nil && resp.ContentLength >= 0 && counter.n != resp.ContentLength:
+		verifyErr = fmt.Errorf("incomplete download of %s: expected %d bytes, got %d", b.Filename, resp.ContentLength, counter.n)
+	default:
+		remoteSize, err := DeviceFileSize(streamCtx, opts.ADB, opts.Serial, remotePath)
+		if err != nil {
+			return err
+		}
+		if remoteSize != counter.n {
+			verifyErr = &sizeMismatchError{RemotePath: remotePath, Expected: counter.n, Actual: remoteSize}
+		}
+	}
+	if verifyErr != nil {
+		if err := DeleteDeviceFile(streamCtx, opts.ADB, opts.Serial, remotePath); err != nil {
+			slog.Warn(err.Error())
+		}
+		return verifyErr
+	}
+
+	return nil
+}
+
+// streamError marks a failure of the streaming push itself, as opposed to the download,
+// so the caller can fall back to pushing from a temp file.
+type streamError struct {
+	err error
+}
+
+func (e *streamError) Error() string {
+	return e.err.Error()
+}
+
+func (e *streamError) Unwrap() error {
+	return e.err
+}
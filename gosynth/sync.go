@@ -0,0 +1,681 @@
+package gosynth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDownloadTimeout is how long a single beatmap download may take by default
+const DefaultDownloadTimeout = 5 * time.Minute
+
+// downloadClient is used for beatmap downloads. It has no overall timeout, since large
+// files on slow links legitimately take a while; instead connections that never answer
+// fail quickly and each download gets a deadline through its context.
+var downloadClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 proxyFor,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+// downloadURL returns the full URL a beatmap is downloaded from. Absolute download URLs
+// from the API are used as they are; relative ones are resolved against the download base.
+func downloadURL(b Beatmap) string {
+	ref, err := url.Parse(b.DownloadUrl)
+	if err != nil {
+		// Let the request fail with a descriptive error
+		return server.DownloadBaseURL + b.DownloadUrl
+	}
+	if ref.IsAbs() {
+		return ref.String()
+	}
+	base, err := url.Parse(server.DownloadBaseURL)
+	if err != nil {
+		return server.DownloadBaseURL + b.DownloadUrl
+	}
+	return base.ResolveReference(ref).String()
+}
+
+// downloadAndPushBeatmap downloads a beatmap and pushes it to the device. When a cache
+// dir is set, downloads are kept there and a cached file of the expected size is pushed
+// without downloading it again. With Unpack, zipped beatmaps are extracted and the paths
+// of the extracted files, relative to the remote dir, are returned.
+func downloadAndPushBeatmap(ctx context.Context, b Beatmap, opts SyncOptions) ([]string, error) {
+	serial, remoteDir, cacheDir := opts.Serial, opts.RemoteDir, opts.CacheDir
+	if opts.OutputDir != "" {
+		return nil, saveBeatmap(ctx, b, opts)
+	}
+	if serial == "" {
+		return nil, fmt.Errorf("cannot push %s: no device serial given", b.Filename)
+	}
+
+	fullURL := downloadURL(b)
+
+	// Give up on a download that takes too long so a stalled CDN connection can't block
+	// the whole sync
+	timeout := opts.DownloadTimeout
+	if timeout <= 0 {
+		timeout = DefaultDownloadTimeout
+	}
+	dlCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Pipe the download straight to the device, falling back to a temp file if this
+	// adb can't stream. After a shutdown was requested, or for a beatmap that is simply
+	// too big, a temp file won't help.
+	if opts.Stream && !(opts.Unpack && isZipArchive(b.Filename)) {
+		err := streamBeatmap(ctx, fullURL, b, opts)
+		var streamErr *streamError
+		if !errors.As(err, &streamErr) || ctx.Err() != nil || errors.Is(err, ErrTooLarge) {
+			return nil, err
+		}
+		slog.Warn(fmt.Sprintf("streaming %s failed, retrying via a temp file: %v", b.Filename, err))
+		cacheDir = ""
+		// The streamed copy was deleted again
+		opts.space.release()
+	}
+
+	// Step 1: Reuse a cached copy or download the file
+	var localPath string
+	var size int64
+	var err error
+	keepTemp := false
+	if cacheDir != "" {
+		if localPath, err = localBeatmapPath(cacheDir, b.Filename); err != nil {
+			return nil, err
+		}
+		if size, err = fetchToCache(dlCtx, fullURL, localPath, b, opts); err != nil {
+			return nil, fmt.Errorf("failed to download %s: %w", b.Filename, err)
+		}
+	} else {
+		// Give every download its own temp file so concurrent or repeated downloads of the
+		// same beatmap can't clobber each other
+		tmp, err := os.CreateTemp(opts.TempDir, "gosynth-*-"+filepath.Base(b.Filename))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp file for %s: %w", b.Filename, err)
+		}
+		tmp.Close()
+		localPath = tmp.Name()
+
+		// Always clean up the temp file, even if the download or push is cancelled midway,
+		// unless the push was truncated and the file is kept for a retry. Partial downloads
+		// are only worth resuming from the cache.
+		defer func() {
+			os.Remove(localPath + ".part")
+			if keepTemp {
+				return
+			}
+			if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
+				slog.Warn(fmt.Sprintf("failed to delete temp file %s: %v", localPath, err))
+			}
+		}()
+		size, err = downloadBeatmap(dlCtx, fullURL, localPath, b.Hash, opts.MaxDownloadSize, opts.space, opts.DownloadProgress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download %s: %w", b.Filename, err)
+		}
+	}
+
+	// Step 2: Push to device, one push at a time per device
+	if opts.Unpack && isZipArchive(b.Filename) {
+		return unpackAndPush(ctx, localPath, opts)
+	}
+	err = pushBeatmap(ctx, opts.ADB, serial, localPath, remoteDir, b.Filename, size)
+	if err != nil {
+		var mismatch *sizeMismatchError
+		if errors.As(err, &mismatch) {
+			keepTemp = true
+			return nil, fmt.Errorf("%w (kept %s for retry)", err, localPath)
+		}
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// saveBeatmap downloads a beatmap into opts.OutputDir instead of pushing it to a device.
+// A copy that is already there and intact is kept. With Unpack, zipped beatmaps are
+// extracted into the folder and the archive is removed.
+func saveBeatmap(ctx context.Context, b Beatmap, opts SyncOptions) error {
+	timeout := opts.DownloadTimeout
+	if timeout <= 0 {
+		timeout = DefaultDownloadTimeout
+	}
+	dlCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	fullURL := downloadURL(b)
+	destPath, err := localBeatmapPath(opts.OutputDir, b.Filename)
+	if err != nil {
+		return err
+	}
+	if _, err := cachedBeatmapSize(dlCtx, fullURL, destPath, b.Hash); err == nil {
+		slog.Info(fmt.Sprintf("%s is already in %s", b.Filename, opts.OutputDir))
+	} else if _, err := downloadBeatmap(dlCtx, fullURL, destPath, b.Hash, opts.MaxDownloadSize, nil, opts.DownloadProgress); err != nil {
+		return fmt.Errorf("failed to download %s: %w", b.Filename, err)
+	}
+
+	if opts.Unpack && isZipArchive(b.Filename) {
+		if _, err := extractZip(destPath, opts.OutputDir); err != nil {
+			return fmt.Errorf("failed to unpack %s: %w", b.Filename, err)
+		}
+		return os.Remove(destPath)
+	}
+	return nil
+}
+
+// downloadBeatmap saves the file at url to destPath and returns its size. The data is
+// written to a ".part" file first so an interrupted or corrupted download never looks
+// complete. If an earlier attempt left a ".part" file behind, the download resumes from
+// where it stopped when the server supports Range requests. The download is verified
+// against expectedHash when the API provided one, and against the Content-Length
+// otherwise. Downloads bigger than maxSize are aborted unless maxSize is zero, and so are
+// downloads that don't fit into space. Progress updates are written to progress unless it
+// is nil.
+func downloadBeatmap(ctx context.Context, url string, destPath string, expectedHash string, maxSize int64, space *spaceClaim, progress io.Writer) (int64, error) {
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	partPath := destPath + ".part"
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil && info.Size() > 0 {
+		offset = info.Size()
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := doRequest(downloadClient, req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var body io.Reader = resp.Body
+	var outFile *os.File
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			os.Remove(partPath)
+			return 0, fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+		slog.Debug(fmt.Sprintf("resuming %s at %s", filepath.Base(destPath), FormatBytes(offset)))
+		outFile, err = os.OpenFile(partPath, os.O_WRONLY|os.O_APPEND, 0o644)
+	case resp.StatusCode == http.StatusOK:
+		// Either nothing to resume or the server ignored the Range header; start over
+		offset = 0
+		if body, err = checkBeatmapBody(resp); err != nil {
+			return 0, err
+		}
+		outFile, err = os.Create(partPath)
+	default:
+		// A stale partial file can make the range unsatisfiable; start over next time
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			os.Remove(partPath)
+		}
+		return 0, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
+	recordDownloadStart(resp.ContentLength)
+	if maxSize > 0 {
+		if resp.ContentLength >= 0 && offset+resp.ContentLength > maxSize {
+			outFile.Close()
+			os.Remove(partPath)
+			return 0, tooLargeError(maxSize)
+		}
+		body = &cappedReader{r: body, max: maxSize - offset}
+	}
+	if resp.ContentLength >= 0 {
+		if err := space.reserve(offset + resp.ContentLength); err != nil {
+			outFile.Close()
+			if offset == 0 {
+				os.Remove(partPath)
+			}
+			return 0, err
+		}
+	}
+
+	var checksum hash.Hash
+	if expectedHash != "" {
+		if checksum, err = newChecksum(expectedHash); err != nil {
+			slog.Debug(fmt.Sprintf("not verifying %s: %v", filepath.Base(destPath), err))
+		}
+	}
+	// The checksum covers the whole file, including the part downloaded earlier
+	if checksum != nil && offset > 0 {
+		if err := hashFile(checksum, partPath); err != nil {
+			outFile.Close()
+			return 0, fmt.Errorf("failed to read partial download: %w", err)
+		}
+	}
+
+	if progress != nil {
+		total := int64(-1)
+		if resp.ContentLength >= 0 {
+			total = offset + resp.ContentLength
+		}
+		reader := newProgressReader(body, filepath.Base(destPath), total, progress)
+		reader.read = offset
+		body = reader
+	}
+
+	var dest io.Writer = outFile
+	if checksum != nil {
+		dest = io.MultiWriter(outFile, checksum)
+	}
+
+	written, err := io.Copy(dest, body)
+	outFile.Close()
+	if errors.Is(err, ErrTooLarge) {
+		os.Remove(partPath)
+		return 0, err
+	}
+	if err != nil {
+		// Keep what we have so the next attempt can resume
+		return 0, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	// Reject corrupted downloads so they are retried rather than pushed
+	if checksum != nil && !checksumMatches(checksum, expectedHash) {
+		os.Remove(partPath)
+		return 0, fmt.Errorf("checksum mismatch: expected %s", expectedHash)
+	}
+	if checksum == nil && resp.ContentLength >= 0 && written != resp.ContentLength {
+		os.Remove(partPath)
+		return 0, fmt.Errorf("incomplete download: expected %d bytes, got %d", resp.ContentLength, written)
+	}
+
+	if err := os.Rename(partPath, destPath); err != nil {
+		os.Remove(partPath)
+		return 0, fmt.Errorf("failed to save file: %w", err)
+	}
+	recordDownload(written, time.Since(start))
+
+	return offset + written, nil
+}
+
+// zipMagic starts every beatmap, since .synth files are zip archives
+var zipMagic = []byte("PK\x03\x04")
+
+// checkBeatmapBody rejects a download that isn't a beatmap, such as an HTML error page
+// served with status 200, by its Content-Type and its first bytes. It returns a reader
+// that still yields the whole body.
+func checkBeatmapBody(resp *http.Response) (io.Reader, error) {
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		if strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+			return nil, fmt.Errorf("%w: server sent %s", ErrNotBeatmap, mediaType)
+		}
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	head, err := reader.Peek(len(zipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(head, zipMagic) {
+		return nil, fmt.Errorf("%w: the file isn't a zip archive", ErrNotBeatmap)
+	}
+	return reader, nil
+}
+
+// cachedBeatmapSize returns the size of a cached beatmap if it exists and is intact:
+// it must match expectedHash if the API provided one, or else the size the server
+// reports for url.
+func cachedBeatmapSize(ctx context.Context, url string, cachedPath string, expectedHash string) (int64, error) {
+	info, err := os.Stat(cachedPath)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := newChecksum(expectedHash); err == nil {
+		if err := verifyFileChecksum(cachedPath, expectedHash); err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := doRequest(downloadClient, req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != info.Size() {
+		return 0, fmt.Errorf("cached %s is stale", cachedPath)
+	}
+	return info.Size(), nil
+}
+
+// sizeMismatchError reports a pushed file whose size on the device differs from the local copy.
+type sizeMismatchError struct {
+	RemotePath string
+	Expected   int64
+	Actual     int64
+}
+
+// Unwrap lets errors.Is match ErrPushFailed.
+func (e *sizeMismatchError) Unwrap() error {
+	return ErrPushFailed
+}
+
+func (e *sizeMismatchError) Error() string {
+	return fmt.Sprintf("size mismatch for %s: expected %d bytes, device has %d bytes",
+		e.RemotePath, e.Expected, e.Actual)
+}
+
+// PushGracePeriod is how long a push that already started may keep running after the
+// sync is cancelled, so that it doesn't leave a partial file on the device
+const PushGracePeriod = 15 * time.Second
+
+// limitMapTime runs fn for one beatmap with a deadline of timeout, if above zero, so a
+// stuck beatmap can't hold up a worker for long. Failures caused by the deadline are
+// reported as ErrTimedOut.
+func limitMapTime(ctx context.Context, b Beatmap, timeout time.Duration, fn func(context.Context) error) error {
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+	mapCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := fn(mapCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(mapCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("gave up on %s after %v: %w", b.Filename, timeout, ErrTimedOut)
+	}
+	return err
+}
+
+// graceContext returns a context for a push that outlives ctx by grace: once ctx is done,
+// the push gets grace more time before it is cancelled as well.
+func graceContext(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	pushCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(grace, cancel)
+	})
+	return pushCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// pushBeatmap pushes a local file to remoteDir/filename on the device and verifies that
+// the pushed file wasn't truncated (e.g. because the device ran out of space). Once the
+// push started, cancelling ctx only stops it after PushGracePeriod.
+func pushBeatmap(ctx context.Context, adb ADBClient, serial string, localPath string, remoteDir string, filename string, size int64) error {
+	remotePath, err := remoteBeatmapPath(remoteDir, filename)
+	if err != nil {
+		return err
+	}
+
+	lock := devicePushLock(serial)
+	lock.Lock()
+	// Don't start a push after a shutdown was requested
+	if err := ctx.Err(); err != nil {
+		lock.Unlock()
+		return err
+	}
+	ctx, cancel := graceContext(ctx, PushGracePeriod)
+	defer cancel()
+	start := time.Now()
+	output, err := adb.Push(ctx, serial, localPath, remotePath)
+	recordPush(time.Since(start))
+	lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("adb %w: %w\nOutput: %s", ErrPushFailed, err, string(output))
+	}
+
+	remoteSize, err := DeviceFileSize(ctx, adb, serial, remotePath)
+	if err != nil {
+		return err
+	}
+	if remoteSize != size {
+		return &sizeMismatchError{RemotePath: remotePath, Expected: size, Actual: remoteSize}
+	}
+
+	return nil
+}
+
+// DefaultCacheDir returns the download cache directory (e.g. ~/.cache/gosynth), creating it if needed.
+func DefaultCacheDir() (string, error) {
+	userCache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(userCache, "gosynth")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// cacheLocks serializes downloads into the cache per file, so devices synced in parallel
+// that miss the same beatmap download it once and share the cached copy.
+var (
+	cacheLocksMu sync.Mutex
+	cacheLocks   = make(map[string]*sync.Mutex)
+)
+
+// cacheFileLock returns the mutex for the given cache path.
+func cacheFileLock(cachePath string) *sync.Mutex {
+	cacheLocksMu.Lock()
+	defer cacheLocksMu.Unlock()
+
+	lock, ok := cacheLocks[cachePath]
+	if !ok {
+		lock = &sync.Mutex{}
+		cacheLocks[cachePath] = lock
+	}
+	return lock
+}
+
+// fetchToCache returns the size of the cached copy of a beatmap at cachePath, downloading
+// it first unless a valid copy is already there. Concurrent calls for the same file wait
+// for each other, so only the first one downloads.
+func fetchToCache(ctx context.Context, fullURL string, cachePath string, b Beatmap, opts SyncOptions) (int64, error) {
+	lock := cacheFileLock(cachePath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if size, err := cachedBeatmapSize(ctx, fullURL, cachePath, b.Hash); err == nil {
+		if err := opts.space.reserve(size); err != nil {
+			return 0, err
+		}
+		slog.Info(fmt.Sprintf("Using cached %s", b.Filename))
+		return size, nil
+	}
+	return downloadBeatmap(ctx, fullURL, cachePath, b.Hash, opts.MaxDownloadSize, opts.space, opts.DownloadProgress)
+}
+
+// pushLocks serializes adb pushes per device serial so concurrent downloads don't clobber each other.
+var (
+	pushLocksMu sync.Mutex
+	pushLocks   = make(map[string]*sync.Mutex)
+)
+
+// devicePushLock returns the push mutex for the given device serial.
+func devicePushLock(serial string) *sync.Mutex {
+	pushLocksMu.Lock()
+	defer pushLocksMu.Unlock()
+
+	lock, ok := pushLocks[serial]
+	if !ok {
+		lock = &sync.Mutex{}
+		pushLocks[serial] = lock
+	}
+	return lock
+}
+
+// SyncOptions configures how Sync delivers beatmaps to a device.
+type SyncOptions struct {
+	// ADB runs the adb commands; defaults to NewADBClient()
+	ADB ADBClient
+	// Serial of the device to push to
+	Serial string
+	// RemoteDir is the CustomSongs folder on the device
+	RemoteDir string
+	// CacheDir keeps downloads between runs; empty disables the cache
+	CacheDir string
+	// Workers is the number of beatmaps downloaded concurrently
+	Workers int
+	// DownloadTimeout limits how long each download may take; defaults to DefaultDownloadTimeout
+	DownloadTimeout time.Duration
+	// Stream pipes downloads straight into the device instead of saving them first;
+	// the cache is bypassed and a temp file is only used if streaming fails
+	Stream bool
+	// BatchSize, if above zero, downloads this many beatmaps into a staging folder and
+	// pushes the folder with a single adb call; Stream and Unpack are ignored in this mode
+	BatchSize int
+	// Unpack extracts .zip beatmaps and pushes their contents instead of the archive
+	Unpack bool
+	// PerMapTimeout, if above zero, limits how long each beatmap may take from the start
+	// of its download to the end of its push; beatmaps over it fail with ErrTimedOut
+	PerMapTimeout time.Duration
+	// MaxDownloadSize, if above zero, aborts downloads of beatmaps bigger than this many bytes
+	MaxDownloadSize int64
+	// FreeSpace, if above zero, is the number of bytes free on the device. Each beatmap's
+	// size, from the Content-Length or the cached copy, is taken from it as its download
+	// starts, and beatmaps that no longer fit fail with ErrNoSpace. Downloads whose size
+	// the server doesn't report aren't checked.
+	FreeSpace int64
+	// TempDir holds downloads that aren't cached and staging folders; empty uses os.TempDir()
+	TempDir string
+	// OutputDir, if set, saves the beatmaps to this local folder instead of pushing them;
+	// no device is needed and the device-related options are ignored
+	OutputDir string
+	// DownloadProgress receives throttled byte-count updates for each download; nil silences them
+	DownloadProgress io.Writer
+	// OnResult, if set, is called after each beatmap is processed. Calls are never concurrent.
+	OnResult func(BeatmapResult)
+
+	// space is the share of FreeSpace taken by the beatmap being synced
+	space *spaceClaim
+}
+
+// BeatmapResult is the outcome of syncing a single beatmap.
+type BeatmapResult struct {
+	Beatmap Beatmap
+	// Unpacked lists the files a zipped beatmap was extracted into with Unpack, relative
+	// to RemoteDir; the archive itself never reaches the device
+	Unpacked []string
+	// Err is nil if the beatmap was pushed successfully
+	Err error
+}
+
+// Sync downloads the given beatmaps and pushes them to the device using a pool of
+// workers, and returns how many succeeded and failed. Pushes are serialized per device.
+func Sync(ctx context.Context, beatmaps []Beatmap, opts SyncOptions) (succeeded int, failed int) {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if opts.ADB == nil {
+		opts.ADB = NewADBClient()
+	}
+
+	var mu sync.Mutex
+	recordResult := func(result BeatmapResult) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if result.Err != nil {
+			failed++
+		} else {
+			succeeded++
+		}
+		if opts.OnResult != nil {
+			opts.OnResult(result)
+		}
+	}
+	record := func(bm Beatmap, err error) {
+		recordResult(BeatmapResult{Beatmap: bm, Err: err})
+	}
+
+	// Never let a bad filename reach a local or device path
+	var valid []Beatmap
+	for _, bm := range beatmaps {
+		if err := CheckFilename(bm.Filename); err != nil {
+			record(bm, err)
+			continue
+		}
+		valid = append(valid, bm)
+	}
+	beatmaps = valid
+
+	var budget *spaceBudget
+	if opts.OutputDir == "" {
+		budget = newSpaceBudget(opts.FreeSpace)
+	}
+
+	if opts.BatchSize > 0 && opts.OutputDir == "" {
+		for start := 0; start < len(beatmaps); start += opts.BatchSize {
+			if ctx.Err() != nil {
+				slog.Warn("Sync cancelled, skipping remaining beatmaps.")
+				break
+			}
+			end := min(start+opts.BatchSize, len(beatmaps))
+			syncBatch(ctx, beatmaps[start:end], opts, workers, budget, record)
+		}
+		return succeeded, failed
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan Beatmap)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for bm := range jobs {
+				mapOpts := opts
+				mapOpts.space = budget.claim()
+				var unpacked []string
+				err := limitMapTime(ctx, bm, opts.PerMapTimeout, func(ctx context.Context) error {
+					var err error
+					unpacked, err = downloadAndPushBeatmap(ctx, bm, mapOpts)
+					return err
+				})
+				if err != nil {
+					mapOpts.space.release()
+				}
+				recordResult(BeatmapResult{Beatmap: bm, Unpacked: unpacked, Err: err})
+			}
+		}()
+	}
+
+	for _, bm := range beatmaps {
+		if ctx.Err() != nil {
+			slog.Warn("Sync cancelled, skipping remaining beatmaps.")
+			break
+		}
+		jobs <- bm
+	}
+	close(jobs)
+	wg.Wait()
+
+	return succeeded, failed
+}
@@ -0,0 +1,179 @@
+package gosynth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDownloadAndPushBeatmapEmptySerial(t *testing.T) {
+	b := Beatmap{Filename: "song.synth", DownloadUrl: "/api/beatmaps/1/download"}
+
+	_, err := downloadAndPushBeatmap(context.Background(), b, SyncOptions{})
+	if err == nil {
+		t.Fatal("expected an error for an empty serial")
+	}
+	if !strings.Contains(err.Error(), "no device serial") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDownloadURL(t *testing.T) {
+	SetServerConfig(ServerConfig{DownloadBaseURL: "https://mirror.example.com/synth/"})
+	defer SetServerConfig(ServerConfig{})
+
+	tests := []struct {
+		downloadURL string
+		want        string
+	}{
+		{"/api/beatmaps/1/download", "https://mirror.example.com/api/beatmaps/1/download"},
+		{"api/beatmaps/1/download", "https://mirror.example.com/synth/api/beatmaps/1/download"},
+		{"https://cdn.example.com/files/song.synth", "https://cdn.example.com/files/song.synth"},
+		{"/api/beatmaps/2/download?token=abc", "https://mirror.example.com/api/beatmaps/2/download?token=abc"},
+	}
+	for _, tt := range tests {
+		if got := downloadURL(Beatmap{DownloadUrl: tt.downloadURL}); got != tt.want {
+			t.Errorf("downloadURL(%q) = %q, want %q", tt.downloadURL, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadBeatmapRejectsHTML(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"html content type", "text/html; charset=utf-8", "<html><body>Please log in</body></html>"},
+		{"no content type", "", "<!DOCTYPE html><html></html>"},
+		{"json error", "application/json", `{"error":"not found"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				// Keep net/http from sniffing a content type when the test wants none
+				w.Header()["Content-Type"] = nil
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(tt.body))
+			})
+
+			dest := filepath.Join(t.TempDir(), "song.synth")
+			_, err := downloadBeatmap(context.Background(), srv.URL+"/song.synth", dest, "", 0, nil, nil)
+			if !errors.Is(err, ErrNotBeatmap) {
+				t.Fatalf("got %v, want ErrNotBeatmap", err)
+			}
+			if _, err := os.Stat(dest); !os.IsNotExist(err) {
+				t.Error("the rejected download was saved")
+			}
+		})
+	}
+}
+
+func TestDownloadBeatmapAcceptsZip(t *testing.T) {
+	body := "PK\x03\x04rest of the archive"
+	srv := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte(body))
+	})
+
+	dest := filepath.Join(t.TempDir(), "song.synth")
+	size, err := downloadBeatmap(context.Background(), srv.URL+"/song.synth", dest, "", 0, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(dest); err != nil || string(data) != body || size != int64(len(body)) {
+		t.Errorf("got %q (%d bytes), %v", data, size, err)
+	}
+}
+
+func TestStreamBeatmapDeletesPartialFile(t *testing.T) {
+	srv := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("PK\x03\x04rest of the archive"))
+	})
+	adb := &fakeADB{pushErr: errors.New("exit status 1")}
+
+	b := Beatmap{Filename: "song.synth"}
+	err := streamBeatmap(context.Background(), srv.URL+"/song.synth", b, SyncOptions{ADB: adb, Serial: "serial", RemoteDir: "/sdcard/Songs"})
+	var streamErr *streamError
+	if !errors.As(err, &streamErr) {
+		t.Fatalf("got %v, want a streaming error", err)
+	}
+	want := []string{"rm", "-f", "/sdcard/Songs/song.synth"}
+	if len(adb.shellCalls) != 1 || !reflect.DeepEqual(adb.shellCalls[0], want) {
+		t.Errorf("ran %q, want %q", adb.shellCalls, want)
+	}
+}
+
+func TestStreamAfterShutdown(t *testing.T) {
+	var requests atomic.Int32
+	newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte("PK\x03\x04rest of the archive"))
+	})
+	adb := &fakeADB{}
+
+	// A shutdown neither starts the push nor falls back to downloading via a temp file
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b := Beatmap{Filename: "song.synth", DownloadUrl: "/song.synth"}
+	_, err := downloadAndPushBeatmap(ctx, b, SyncOptions{ADB: adb, Serial: "serial", RemoteDir: "/sdcard/Songs", Stream: true})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if len(adb.pushed) > 0 {
+		t.Error("pushed after the shutdown")
+	}
+	if n := requests.Load(); n > 1 {
+		t.Errorf("downloaded %d times", n)
+	}
+}
+
+func TestSyncFreeSpace(t *testing.T) {
+	body := "PK\x03\x04" + strings.Repeat("x", 96)
+	newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte(body))
+	})
+	adb := &fakeADB{shell: func(args []string) ([]byte, error) {
+		return []byte(strconv.Itoa(len(body))), nil
+	}}
+	beatmaps := []Beatmap{
+		{Filename: "a.synth", DownloadUrl: "/a.synth"},
+		{Filename: "b.synth", DownloadUrl: "/b.synth"},
+		{Filename: "c.synth", DownloadUrl: "/c.synth"},
+	}
+
+	// Room for two of the three beatmaps, which only their downloads tell
+	var noSpace []string
+	succeeded, failed := Sync(context.Background(), beatmaps, SyncOptions{
+		ADB:       adb,
+		Serial:    "serial",
+		RemoteDir: "/sdcard/Songs",
+		TempDir:   t.TempDir(),
+		FreeSpace: 250,
+		OnResult: func(result BeatmapResult) {
+			if errors.Is(result.Err, ErrNoSpace) {
+				noSpace = append(noSpace, result.Beatmap.Filename)
+			}
+		},
+	})
+	if succeeded != 2 || failed != 1 {
+		t.Errorf("got %d succeeded and %d failed", succeeded, failed)
+	}
+	if !reflect.DeepEqual(noSpace, []string{"c.synth"}) {
+		t.Errorf("%q didn't fit, want c.synth", noSpace)
+	}
+	if len(adb.pushed) != 2 {
+		t.Errorf("pushed %d beatmaps", len(adb.pushed))
+	}
+}
@@ -0,0 +1,136 @@
+package gosynth
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// isZipArchive reports whether a beatmap is distributed as a .zip that needs unpacking.
+// .synth files are zip archives too, so only the extension is checked.
+func isZipArchive(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".zip")
+}
+
+// extractedFile is a file unpacked from an archive.
+type extractedFile struct {
+	// name is the path inside the archive, with forward slashes
+	name string
+	size int64
+}
+
+// extractZip unpacks a zip archive into destDir. It refuses archives with entries that
+// would land outside destDir (zip-slip) or that are symlinks.
+func extractZip(zipPath string, destDir string) ([]extractedFile, error) {
+	archive, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %w", err)
+	}
+	defer archive.Close()
+
+	// Validate every entry before writing anything
+	for _, f := range archive.File {
+		if !filepath.IsLocal(f.Name) || strings.Contains(f.Name, `\`) {
+			return nil, fmt.Errorf("refusing archive with unsafe path %q", f.Name)
+		}
+		if f.Mode()&os.ModeSymlink != 0 {
+			return nil, fmt.Errorf("refusing archive with symlink %q", f.Name)
+		}
+	}
+
+	var files []extractedFile
+	for _, f := range archive.File {
+		target := filepath.Join(destDir, filepath.FromSlash(f.Name))
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return nil, err
+		}
+		size, err := extractZipFile(f, target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract %s: %w", f.Name, err)
+		}
+		files = append(files, extractedFile{name: path.Clean(f.Name), size: size})
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("archive is empty")
+	}
+	return files, nil
+}
+
+// extractZipFile writes a single archive entry to target and returns its size.
+func extractZipFile(f *zip.File, target string) (int64, error) {
+	in, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return written, err
+}
+
+// unpackAndPush extracts a zipped beatmap and pushes its contents into remoteDir,
+// checking that every file arrived intact. It returns the paths of the pushed files
+// relative to remoteDir.
+func unpackAndPush(ctx context.Context, zipPath string, opts SyncOptions) ([]string, error) {
+	dir, err := os.MkdirTemp(opts.TempDir, "gosynth-unpack-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create unpack folder: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files, err := extractZip(zipPath, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack %s: %w", filepath.Base(zipPath), err)
+	}
+
+	lock := devicePushLock(opts.Serial)
+	lock.Lock()
+	if err := ctx.Err(); err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	ctx, cancel := graceContext(ctx, PushGracePeriod)
+	defer cancel()
+	start := time.Now()
+	output, err := opts.ADB.Push(ctx, opts.Serial, dir+string(filepath.Separator)+".", opts.RemoteDir)
+	recordPush(time.Since(start))
+	lock.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("adb %w: %w\nOutput: %s", ErrPushFailed, err, output)
+	}
+
+	var names []string
+	for _, f := range files {
+		remotePath := path.Join(opts.RemoteDir, f.name)
+		remoteSize, err := DeviceFileSize(ctx, opts.ADB, opts.Serial, remotePath)
+		if err != nil {
+			return nil, err
+		}
+		if remoteSize != f.size {
+			return nil, &sizeMismatchError{RemotePath: remotePath, Expected: f.size, Actual: remoteSize}
+		}
+		names = append(names, f.name)
+	}
+	return names, nil
+}
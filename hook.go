@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hookTimeout caps how long a --post-push-hook may run for one beatmap
+const hookTimeout = time.Minute
+
+// runPostPushHook runs the --post-push-hook command after a beatmap was pushed. The
+// command runs through the shell with the filename and remote path as $1 and $2, and
+// with these environment variables:
+//
+//	GOSYNTH_FILENAME     file name of the beatmap
+//	GOSYNTH_REMOTE_PATH  where the beatmap was pushed on the device
+//	GOSYNTH_SERIAL       serial of the device
+//
+// A failing hook is logged but doesn't fail the sync.
+func runPostPushHook(ctx context.Context, hook string, serial string, remotePath string, log *slog.Logger) {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+
+	filename := path.Base(remotePath)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", hook, filename, remotePath)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", hook, "post-push-hook", filename, remotePath)
+	}
+	cmd.Env = append(os.Environ(),
+		"GOSYNTH_FILENAME="+filename,
+		"GOSYNTH_REMOTE_PATH="+remotePath,
+		"GOSYNTH_SERIAL="+serial,
+	)
+
+	output, err := cmd.CombinedOutput()
+	out := strings.TrimSpace(string(output))
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		msg := fmt.Sprintf("post-push hook for %s exited with code %d", filename, exitErr.ExitCode())
+		if out != "" {
+			msg += ": " + out
+		}
+		log.Warn(msg)
+	case err != nil:
+		log.Warn(fmt.Sprintf("post-push hook for %s failed: %v", filename, err))
+	case out != "":
+		log.Debug(fmt.Sprintf("post-push hook for %s: %s", filename, out))
+	}
+}
+
+// hookQueue runs post-push hooks one at a time in the background, in the order the
+// beatmaps were pushed, so a slow hook doesn't hold up the workers reporting results.
+type hookQueue struct {
+	ctx    context.Context
+	hook   string
+	serial string
+	log    *slog.Logger
+
+	mu      sync.Mutex
+	pending []string
+	closed  bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+// startHooks starts running hook for the remote paths added to the queue. Without a hook
+// it returns nil, which accepts paths and ignores them.
+func startHooks(ctx context.Context, hook string, serial string, log *slog.Logger) *hookQueue {
+	if hook == "" {
+		return nil
+	}
+	q := &hookQueue{
+		ctx:    ctx,
+		hook:   hook,
+		serial: serial,
+		log:    log,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *hookQueue) run() {
+	defer close(q.done)
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			closed := q.closed
+			q.mu.Unlock()
+			if closed {
+				return
+			}
+			<-q.wake
+			continue
+		}
+		remotePath := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		runPostPushHook(q.ctx, q.hook, q.serial, remotePath, q.log)
+	}
+}
+
+// add queues the hook for a pushed file without waiting for it to run.
+func (q *hookQueue) add(remotePath string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.pending = append(q.pending, remotePath)
+	q.mu.Unlock()
+	q.notify()
+}
+
+// wait lets the queued hooks finish; nothing may be added afterwards.
+func (q *hookQueue) wait() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.notify()
+	<-q.done
+}
+
+func (q *hookQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/ninjaki8/GoSynth/gosynth"
+)
+
+// deviceListing is the JSON output of the list command.
+type deviceListing struct {
+	Serial string   `json:"serial"`
+	Count  int      `json:"count"`
+	Files  []string `json:"files"`
+}
+
+// defineListFlags registers the flags of the list command.
+func defineListFlags(flags *flag.FlagSet, opts *options) {
+	defineDeviceFlags(flags, opts)
+	flags.BoolVar(&opts.recursive, "recursive", false, "also list the files in subfolders, relative to --remote-dir")
+	flags.BoolVar(&opts.jsonOutput, "json", false, "print the listing as JSON on stdout; all other output goes to stderr")
+	defineOutputFlags(flags, opts)
+}
+
+// runList implements `gosynth list`: it prints the contents of the device's songs folder
+// without contacting synthriderz.com.
+func runList(ctx context.Context, opts *options, _ *console) int {
+	adb, err := newADB(opts)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitFatal
+	}
+	serials, err := pickDevices(ctx, adb, opts)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitFatal
+	}
+	serial := serials[0]
+
+	if err := gosynth.CheckDeviceFolder(ctx, adb, opts.remoteDir, serial); err != nil {
+		slog.Error(fmt.Sprintf("%v; check --remote-dir", err))
+		return exitFatal
+	}
+	var files []string
+	if opts.recursive {
+		files, err = gosynth.ListDeviceSongsRecursive(ctx, adb, opts.remoteDir, serial)
+	} else {
+		files, err = gosynth.ListDeviceSongs(ctx, adb, opts.remoteDir, serial)
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		return exitFatal
+	}
+
+	if opts.jsonOutput {
+		listing := deviceListing{Serial: serial, Count: len(files), Files: files}
+		if listing.Files == nil {
+			listing.Files = []string{}
+		}
+		if err := writeJSON(listing); err != nil {
+			slog.Error(fmt.Sprintf("Failed to write JSON report: %v", err))
+			return exitFatal
+		}
+		return exitOK
+	}
+
+	for _, file := range files {
+		fmt.Println(file)
+	}
+	slog.Info(fmt.Sprintf("%d files in %s on %s", len(files), opts.remoteDir, serial))
+	return exitOK
+}
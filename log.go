@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// console serializes terminal output so log lines never get mixed into the progress
+// bar. While a status line is shown, each write clears it and draws it again below.
+type console struct {
+	mu     sync.Mutex
+	out    io.Writer
+	status string
+	// tty is set when out is a terminal
+	tty bool
+}
+
+func (c *console) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.status != "" {
+		fmt.Fprint(c.out, "\r\033[K")
+	}
+	n, err := c.out.Write(b)
+	if c.status != "" {
+		fmt.Fprint(c.out, c.status)
+	}
+	return n, err
+}
+
+// setStatus replaces the status line shown at the bottom of the terminal.
+func (c *console) setStatus(status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprint(c.out, "\r\033[K"+status)
+	c.status = status
+}
+
+// clearStatus removes the status line.
+func (c *console) clearStatus() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.status != "" {
+		fmt.Fprint(c.out, "\r\033[K")
+	}
+	c.status = ""
+}
+
+// consoleHandler is a slog.Handler that prints friendly one-line messages instead of
+// key=value records. Warnings and errors get an emoji prefix; attributes are appended.
+type consoleHandler struct {
+	level slog.Leveler
+	out   io.Writer
+	attrs []slog.Attr
+}
+
+func newConsoleHandler(out io.Writer, level slog.Leveler) *consoleHandler {
+	return &consoleHandler{level: level, out: out}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString("❌ ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("⚠️ Warning: ")
+	case r.Level < slog.LevelInfo:
+		b.WriteString("[debug] ")
+	}
+	b.WriteString(r.Message)
+
+	writeAttr := func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range h.attrs {
+		writeAttr(a)
+	}
+	r.Attrs(writeAttr)
+	b.WriteString("\n")
+
+	_, err := io.WriteString(h.out, b.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	// Groups aren't used by this tool; keep attributes flat
+	return h
+}
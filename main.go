@@ -1,324 +1,1584 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
-	"log"
-	"net"
-	"net/http"
+	"log/slog"
+	"net/url"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"os/signal"
+	"path"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+
+	"github.com/ninjaki8/GoSynth/gosynth"
 )
 
-type Device struct {
-	Serial string
-	Model  string
+// noDevicesHelp guides users who haven't set up their headset for adb yet
+const noDevicesHelp = `Make sure that:
+  - the headset is plugged in with a data-capable USB cable (or use --connect for Wi-Fi)
+  - developer mode is enabled for the headset in the Meta Horizon app
+  - you accepted the "Allow USB debugging" prompt inside the headset`
+
+// adbInstallHelp explains how to install adb on this platform.
+func adbInstallHelp() string {
+	var install string
+	switch runtime.GOOS {
+	case "darwin":
+		install = "brew install android-platform-tools"
+	case "windows":
+		install = "winget install Google.PlatformTools"
+	default:
+		install = "sudo apt install adb (Debian/Ubuntu), sudo dnf install android-tools (Fedora) or sudo pacman -S android-tools (Arch)"
+	}
+	return `Install adb with:
+  ` + install + `
+or download the platform-tools from https://developer.android.com/tools/releases/platform-tools.
+If adb is installed somewhere else, point gosynth at it with --adb-path or the ADB environment variable.`
 }
 
-// Beatmap represents a single beatmap entry in the API response
-type Beatmap struct {
-	Filename    string `json:"filename"`
-	DownloadUrl string `json:"download_url"`
+// newADB returns a client for the configured adb binary, or an error explaining how to
+// install adb if it can't be found.
+func newADB(opts *options) (gosynth.ADBClient, error) {
+	bin, err := gosynth.FindADB(opts.adbPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w\n%s", err, adbInstallHelp())
+	}
+	return gosynth.NewADBClientWithPath(bin), nil
 }
 
-// BeatmapPage represents a single paginated response from the API
-type BeatmapPage struct {
-	Data      []Beatmap `json:"data"`
-	Count     int       `json:"count"`
-	Total     int       `json:"total"`
-	Page      int       `json:"page"`
-	PageCount int       `json:"pageCount"`
+// serverConfig returns the API and download URLs from --endpoint and --download-base,
+// falling back to their environment variables. Both must be absolute http(s) URLs. A
+// --proxy must be an http, https, socks5 or socks5h URL.
+func serverConfig(opts *options) (gosynth.ServerConfig, error) {
+	cfg := gosynth.ServerConfig{APIBaseURL: opts.endpoint, DownloadBaseURL: opts.downloadBase}
+	if cfg.APIBaseURL == "" {
+		cfg.APIBaseURL = os.Getenv("SYNTHRIDERZ_API")
+	}
+	if cfg.DownloadBaseURL == "" {
+		cfg.DownloadBaseURL = os.Getenv("SYNTHRIDERZ_DOWNLOAD_BASE")
+	}
+	cfg.APIToken = opts.apiToken
+	if cfg.APIToken == "" {
+		cfg.APIToken = os.Getenv("SYNTHRIDERZ_API_TOKEN")
+	}
+	// Don't echo the token in the error
+	if strings.ContainsAny(cfg.APIToken, " \t\r\n") {
+		return cfg, fmt.Errorf("invalid --api-token: it must not contain whitespace")
+	}
+
+	for _, setting := range []struct{ name, value string }{
+		{"--endpoint", cfg.APIBaseURL},
+		{"--download-base", cfg.DownloadBaseURL},
+	} {
+		if setting.value == "" {
+			continue
+		}
+		u, err := url.Parse(setting.value)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %s: %w", setting.name, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return cfg, fmt.Errorf("invalid %s %q: expected an http or https URL", setting.name, setting.value)
+		}
+	}
+
+	if opts.proxy != "" {
+		u, err := url.Parse(opts.proxy)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid --proxy: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return cfg, fmt.Errorf("invalid --proxy %q: expected an http, https or socks5 URL", opts.proxy)
+		}
+		if u.Host == "" {
+			return cfg, fmt.Errorf("invalid --proxy %q: missing host", opts.proxy)
+		}
+		cfg.Proxy = u
+	}
+	return cfg, nil
 }
 
-const apiEndpoint = "https://synthriderz.com/api/beatmaps"
+// deviceStateHint explains how to fix a device that adb sees but can't use.
+func deviceStateHint(state string) string {
+	switch state {
+	case "unauthorized":
+		return "put on the headset and accept the \"Allow USB debugging\" prompt"
+	case "offline":
+		return "unplug and reconnect the headset, or restart it"
+	case "no permissions":
+		return "adb lacks USB permissions; on Linux install the android udev rules or add yourself to the plugdev group"
+	default:
+		return "reconnect the device and check that adb can access it"
+	}
+}
 
-// Reusable HTTP client with timeout
-var client = &http.Client{Timeout: 10 * time.Second}
+// selectDevice picks a device and returns its serial number. A serial given on the
+// command line must match a connected, ready device; an index above zero picks that
+// device from the list, numbered like in the prompt; a single ready device is selected
+// automatically; otherwise the user is prompted to choose. Devices that aren't ready
+// are listed greyed out with their state but can't be selected.
+func selectDevice(devices []gosynth.Device, serial string, index int) (string, error) {
+	if len(devices) == 0 {
+		return "", fmt.Errorf("no devices found")
+	}
 
-// fetchPage performs an HTTP GET request for a specific page number and returns the decoded BeatmapPage
-func fetchPage(page int) BeatmapPage {
-	url := fmt.Sprintf("%s?page=%d", apiEndpoint, page)
+	if index != 0 && serial == "" {
+		if index < 1 || index > len(devices) {
+			return "", fmt.Errorf("--device-index %d is out of range; %d devices are connected", index, len(devices))
+		}
+		device := devices[index-1]
+		if !device.Ready() {
+			return "", fmt.Errorf("device %s is %s: %s", device.Serial, device.State, deviceStateHint(device.State))
+		}
+		return device.Serial, nil
+	}
 
-	resp, err := client.Get(url)
-	if err != nil {
-		log.Fatalf("Request failed for page %d: %v", page, err)
+	if serial != "" {
+		for _, device := range devices {
+			if device.Serial != serial {
+				continue
+			}
+			if !device.Ready() {
+				return "", fmt.Errorf("device %s is %s: %s", serial, device.State, deviceStateHint(device.State))
+			}
+			return serial, nil
+		}
+		return "", fmt.Errorf("%w: %s is not connected", gosynth.ErrDeviceNotFound, serial)
+	}
+
+	var ready []gosynth.Device
+	for _, device := range devices {
+		if device.Ready() {
+			ready = append(ready, device)
+		}
+	}
+	if len(ready) == 0 {
+		return "", fmt.Errorf("no device is ready")
+	}
+	if len(ready) == 1 {
+		return ready[0].Serial, nil
 	}
-	defer resp.Body.Close()
 
-	var apiResponse BeatmapPage
-	if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err != nil {
-		log.Fatalf("JSON decode failed for page %d: %v", page, err)
+	// Display devices, greying out the ones that can't be used
+	// Prompts go to stderr so they never end up in --json output
+	fmt.Fprintln(os.Stderr, "Available devices:")
+	for i, device := range devices {
+		line := fmt.Sprintf("%d. Serial: %s, Model: %s", i+1, device.Serial, device.Model)
+		if device.TransportID != "" {
+			line += ", Transport: " + device.TransportID
+		}
+		if !device.Ready() {
+			line = greyOut(fmt.Sprintf("%s (%s)", line, device.State))
+		}
+		fmt.Fprintln(os.Stderr, line)
 	}
 
-	return apiResponse
+	// Prompt user for selection
+	fmt.Fprint(os.Stderr, "Enter the number of the device you want to select: ")
+	var choice int
+	_, err := fmt.Scanf("%d", &choice)
+	if err != nil || choice < 1 || choice > len(devices) {
+		return "", fmt.Errorf("invalid choice")
+	}
+
+	device := devices[choice-1]
+	if !device.Ready() {
+		return "", fmt.Errorf("device %s is %s: %s", device.Serial, device.State, deviceStateHint(device.State))
+	}
+
+	// Return the serial of the selected device
+	return device.Serial, nil
 }
 
-func fetchAllPagesConcurrently(totalPages int) []BeatmapPage {
-	var wg sync.WaitGroup
-	results := make(chan BeatmapPage, totalPages)
+// devicesByModel returns the devices of the given model, e.g. "Quest 3". adb reports
+// models with underscores for spaces, so both are accepted; case is ignored.
+func devicesByModel(devices []gosynth.Device, model string) []gosynth.Device {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(s), " ", "_"))
+	}
 
-	for pageNum := 1; pageNum <= totalPages; pageNum++ {
-		wg.Add(1)
-		page := pageNum // capture loop variable safely
+	var matching []gosynth.Device
+	for _, device := range devices {
+		if normalize(device.Model) == normalize(model) {
+			matching = append(matching, device)
+		}
+	}
+	return matching
+}
 
-		go func() {
-			defer wg.Done()
-			results <- fetchPage(page)
-		}()
+// greyOut dims text on a terminal.
+func greyOut(text string) string {
+	if !isTerminal(os.Stderr) {
+		return text
 	}
+	return "\033[2m" + text + "\033[0m"
+}
 
-	wg.Wait()
-	close(results)
+// promptMu keeps prompts from concurrent device syncs from interleaving
+var promptMu sync.Mutex
+
+// confirm asks a yes/no question on stderr, reads the answer from stdin and reports whether the user answered yes.
+func confirm(question string) bool {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", question)
+	var answer string
+	fmt.Scanln(&answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// options holds the command-line flags.
+type options struct {
+	workers         int
+	concurrency     int
+	serial          string
+	connect         string
+	remoteDir       string
+	noCache         bool
+	dryRun          bool
+	limit           int
+	prune           bool
+	yes             bool
+	verbose         bool
+	quiet           bool
+	difficulties    stringList
+	minRating       float64
+	maxRating       float64
+	requireRating   bool
+	jsonOutput      bool
+	allDevices      bool
+	stream          bool
+	timeout         time.Duration
+	downloadTimeout time.Duration
+	retryFailed     bool
+	force           bool
+	forcePush       bool
+	deepCompare     bool
+	recursive       bool
+	watch           time.Duration
+	postPushHook    string
+	notify          bool
+	pick            bool
+	manifestTTL     time.Duration
+	adbPath         string
+	since           string
+	batchSize       int
+	unpack          bool
+	outputDir       string
+	localDir        string
+	skipOnFull      bool
+	rateLimit       float64
+	include         stringList
+	confirmOver     byteSize
+	confirmCount    int
+	maxDownloadSize byteSize
+	estimateSize    bool
+	exclude         stringList
+	endpoint        string
+	downloadBase    string
+	proxy           string
+	deviceWait      time.Duration
+	model           string
+	deviceIndex     int
+	parallelDevices int
+	transportID     string
+	countOnly       bool
+	deadline        time.Duration
+	quietPages      bool
+	tempDir         string
+	perMapTimeout   time.Duration
+	userAgent       string
+	apiToken        string
+	query           gosynth.Query
+}
+
+// filterKey describes the filters that affect which beatmaps a device should have, and
+// the folder they were compared against.
+func (o *options) filterKey() string {
+	return fmt.Sprintf("difficulty=%s rating=%g-%g require-rating=%t search=%s mapper=%s include=%s exclude=%s since=%s max-size=%d remote-dir=%s recursive=%t",
+		strings.ToLower(o.difficulties.String()), o.minRating, o.maxRating, o.requireRating, o.query.Search, o.query.Mapper,
+		strings.ToLower(o.include.String()), strings.ToLower(o.exclude.String()), o.since, o.maxDownloadSize,
+		path.Clean(o.remoteDir), o.recursive)
+}
+
+// catalog is the server's beatmap list, fetched once and shared by every device.
+type catalog struct {
+	// total is the number of beatmaps the API reports
+	total int
+	// pageCount is the number of pages the API reports
+	pageCount int
+	// all is every beatmap on the server
+	all []gosynth.Beatmap
+	// wanted is the subset of all that passes the filters
+	wanted []gosynth.Beatmap
+	// incomplete is set when some pages couldn't be fetched
+	incomplete bool
+}
 
-	var allPages []BeatmapPage
-	for page := range results {
-		allPages = append(allPages, page)
+// fetchCatalog downloads the remaining pages of the beatmap catalog and applies the filters.
+func fetchCatalog(ctx context.Context, firstPage gosynth.BeatmapPage, opts *options, out *console) *catalog {
+	start := time.Now()
+	tty := !opts.quiet && !opts.quietPages && out.tty
+	var onPage func(done int)
+	if !opts.quietPages {
+		onPage = pageProgress(firstPage.PageCount, out, tty)
+	}
+	allPages, pageErrs := gosynth.FetchAllPages(ctx, firstPage, opts.query, opts.concurrency, onPage)
+	if tty {
+		out.clearStatus()
+	}
+
+	slog.Debug(fmt.Sprintf("Execution time: %v", time.Since(start)))
+	for _, page := range allPages {
+		slog.Debug(fmt.Sprintf("Processed page %d with %d beatmaps", page.Page, len(page.Data)))
+	}
+	if size := opts.query.PageSize; size > 0 && firstPage.PageCount > 1 && len(firstPage.Data) != size {
+		slog.Debug(fmt.Sprintf("the API returned %d beatmaps per page instead of %d", len(firstPage.Data), size))
+	}
+
+	// Report pages that could not be fetched; their beatmaps are skipped this run
+	if len(pageErrs) > 0 {
+		slog.Warn(fmt.Sprintf("failed to fetch %d of %d pages:", len(pageErrs), firstPage.PageCount))
+		for _, err := range pageErrs {
+			slog.Warn(err.Error())
+		}
+	}
+
+	// Merge pages, dropping duplicates and invalid filenames, and make sure nothing went
+	// missing along the way
+	beatmaps := gosynth.CollectBeatmaps(allPages)
+	collected, invalid := 0, 0
+	for _, page := range allPages {
+		collected += len(page.Data)
+		for _, bm := range page.Data {
+			if gosynth.CheckFilename(bm.Filename) != nil {
+				invalid++
+			}
+		}
+	}
+	slog.Info(fmt.Sprintf("Fetched %d pages, %d beatmaps, in %d API requests",
+		firstPage.PageCount, len(beatmaps), gosynth.RequestCount()))
+	if invalid > 0 {
+		slog.Warn(fmt.Sprintf("skipped %d beatmaps with invalid filenames", invalid))
+	}
+	if duplicates := collected - invalid - len(beatmaps); duplicates > 0 {
+		slog.Info(fmt.Sprintf("Collapsed %d beatmaps listed on more than one page", duplicates))
+	}
+	if len(pageErrs) == 0 && len(beatmaps)+invalid != firstPage.Total {
+		slog.Warn(fmt.Sprintf("collected %d beatmaps but the API reports %d", len(beatmaps)+invalid, firstPage.Total))
+	}
+
+	cat := &catalog{
+		total:      firstPage.Total,
+		pageCount:  firstPage.PageCount,
+		all:        beatmaps,
+		wanted:     beatmaps,
+		incomplete: len(pageErrs) > 0,
+	}
+
+	// Narrow the server list down to the requested difficulties
+	if len(opts.difficulties) > 0 {
+		filtered, ok := gosynth.FilterByDifficulty(cat.wanted, opts.difficulties)
+		if !ok {
+			slog.Warn("the API didn't report difficulties; --difficulty is ignored")
+		}
+		cat.wanted = filtered
 	}
 
-	return allPages
+	// Keep the star ratings in range
+	if opts.minRating > 0 || opts.maxRating > 0 || opts.requireRating {
+		filtered, ok := gosynth.FilterByRating(cat.wanted, opts.minRating, opts.maxRating, opts.requireRating)
+		if !ok && !opts.requireRating {
+			slog.Warn("the API didn't report star ratings; --min-rating and --max-rating are ignored")
+		}
+		slog.Info(fmt.Sprintf("Filtered out %d of %d beatmaps by star rating", len(cat.wanted)-len(filtered), len(cat.wanted)))
+		cat.wanted = filtered
+	}
+
+	// Narrow it further by filename; the patterns were validated up front
+	if len(opts.include) > 0 || len(opts.exclude) > 0 {
+		filtered, _ := gosynth.FilterByName(cat.wanted, opts.include, opts.exclude)
+		slog.Info(fmt.Sprintf("%d of %d beatmaps match --include/--exclude", len(filtered), len(cat.wanted)))
+		cat.wanted = filtered
+	}
+
+	return cat
+}
+
+// parseSince turns a --since value into a point in time: a date (2006-01-02), an RFC 3339
+// timestamp, or a duration before now such as "48h" or "7d".
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q: use a date like 2024-05-01, a duration like 7d, or last", value)
+}
+
+// sinceFilter applies --since to the beatmaps wanted on a device. "last" means the time of
+// the device's previous recorded sync; without one, nothing is filtered.
+func sinceFilter(serial string, beatmaps []gosynth.Beatmap, opts *options, log *slog.Logger) []gosynth.Beatmap {
+	if opts.since == "" {
+		return beatmaps
+	}
+
+	var since time.Time
+	if opts.since == "last" {
+		manifestPath, err := gosynth.ManifestPath(serial)
+		if err == nil {
+			var manifest *gosynth.Manifest
+			if manifest, err = gosynth.LoadManifest(manifestPath); err == nil {
+				since = manifest.SyncedAt
+			}
+		}
+		if err != nil || since.IsZero() {
+			log.Warn("no previous sync of this device is recorded; --since last is ignored")
+			return beatmaps
+		}
+	} else {
+		// Already validated in runSync
+		since, _ = parseSince(opts.since, time.Now())
+	}
+
+	filtered, ok := gosynth.FilterSince(beatmaps, since)
+	if !ok {
+		log.Warn("the API didn't report when beatmaps were added; --since is ignored")
+		return beatmaps
+	}
+	log.Info(fmt.Sprintf("%d of %d beatmaps were added since %s", len(filtered), len(beatmaps), since.Format("2006-01-02 15:04")))
+	return filtered
 }
 
-func isAdbServerRunning() bool {
-	conn, err := net.DialTimeout("tcp", "127.0.0.1:5037", 1*time.Second)
+// upToDateReport checks the device's sync manifest and returns a report if the device
+// is known to be in sync with the unchanged catalog, or nil if it needs a full run.
+func upToDateReport(serial string, firstPage gosynth.BeatmapPage, opts *options, log *slog.Logger) *syncReport {
+	if opts.force || opts.forcePush || opts.deepCompare {
+		return nil
+	}
+
+	manifestPath, err := gosynth.ManifestPath(serial)
 	if err != nil {
-		return false
+		return nil
+	}
+	manifest, err := gosynth.LoadManifest(manifestPath)
+	if err != nil || manifest.Filter != opts.filterKey() || !manifest.UpToDate(firstPage, opts.manifestTTL) {
+		return nil
+	}
+
+	log.Info(fmt.Sprintf("✅ Everything up to date on %s (%d beatmaps, last synced %s ago); use --force to check anyway",
+		serial, len(manifest.Files), time.Since(manifest.SyncedAt).Round(time.Minute)))
+	return &syncReport{
+		Serial:      serial,
+		ServerTotal: firstPage.Total,
+		DeviceCount: len(manifest.Files),
+		Present:     len(manifest.Files),
+		UpToDate:    true,
+		Missing:     []string{},
+		Results:     []pushResult{},
 	}
-	conn.Close()
-	return true
 }
 
-func startAdbServer() {
-	cmd := exec.Command("adb", "start-server")
-	output, err := cmd.CombinedOutput()
+// saveManifest records a complete sync of the device so the next run can skip it.
+func saveManifest(serial string, cat *catalog, listing *deviceFiles, opts *options) error {
+	manifestPath, err := gosynth.ManifestPath(serial)
 	if err != nil {
-		fmt.Printf("Failed to start ADB server: %v\n", err)
+		return err
 	}
 
-	fmt.Printf("adb start-server output:\n%s\n", output)
+	manifest := &gosynth.Manifest{
+		Serial:      serial,
+		SyncedAt:    time.Now(),
+		ServerTotal: cat.total,
+		PageCount:   cat.pageCount,
+		Filter:      opts.filterKey(),
+		Files:       append([]string{}, listing.files...),
+		Unpacked:    listing.unpacked,
+	}
+	return manifest.Save(manifestPath)
 }
 
-// listConnectedDevices lists all connected devices and returns a slice of Device structs.
-func listConnectedDevices() ([]Device, error) {
-	cmd := exec.Command("adb", "devices", "-l")
-	output, err := cmd.Output()
+// saveUnpacked records the archives unpacked onto the device in its manifest without
+// marking the sync as complete.
+func saveUnpacked(serial string, listing *deviceFiles) error {
+	manifestPath, err := gosynth.ManifestPath(serial)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	manifest, err := gosynth.LoadManifest(manifestPath)
+	if err != nil {
+		manifest = &gosynth.Manifest{Serial: serial}
 	}
+	manifest.Unpacked = listing.unpacked
+	return manifest.Save(manifestPath)
+}
 
-	var devices []Device
-	scanner := bufio.NewScanner(bytes.NewReader(output))
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "List of devices") || strings.TrimSpace(line) == "" {
+// logDownloadSize reports the total of the estimated beatmap sizes and returns it.
+// Unknown sizes (-1) are left out of the total.
+func logDownloadSize(sizes []int64, log *slog.Logger) int64 {
+	var total int64
+	unknown := 0
+	for _, size := range sizes {
+		if size < 0 {
+			unknown++
 			continue
 		}
+		total += size
+	}
+
+	msg := fmt.Sprintf("Download size: about %s for %d beatmaps", gosynth.FormatBytes(total), len(sizes))
+	if unknown > 0 {
+		msg += fmt.Sprintf(" (%d of unknown size)", unknown)
+	}
+	log.Info(msg)
+	return total
+}
 
-		fields := strings.Fields(line)
-		if len(fields) < 2 || fields[1] != "device" {
+// skipOversized drops the beatmaps whose size is over --max-download-size, recording them
+// as skipped, and returns the rest with their sizes. Beatmaps of unknown size are kept;
+// their download is aborted if it goes over the limit.
+func skipOversized(beatmaps []gosynth.Beatmap, sizes []int64, max int64, report *syncReport) ([]gosynth.Beatmap, []int64) {
+	var keptMaps []gosynth.Beatmap
+	var keptSizes []int64
+	for i, bm := range beatmaps {
+		if sizes[i] > max {
+			report.Skipped = append(report.Skipped, bm.Filename)
 			continue
 		}
+		keptMaps = append(keptMaps, bm)
+		keptSizes = append(keptSizes, sizes[i])
+	}
+	return keptMaps, keptSizes
+}
 
-		serial := fields[0]
-		model := "(unknown)"
-		for _, field := range fields {
-			if strings.HasPrefix(field, "model:") {
-				model = strings.TrimPrefix(field, "model:")
-				break
-			}
+// logSkipped lists the beatmaps skipped for being over --max-download-size.
+func logSkipped(report *syncReport, log *slog.Logger) {
+	if len(report.Skipped) == 0 {
+		return
+	}
+	log.Warn(fmt.Sprintf("skipped %d beatmaps over --max-download-size:", len(report.Skipped)))
+	for _, name := range report.Skipped {
+		log.Warn(fmt.Sprintf("  %s", name))
+	}
+}
+
+// logInterrupted lists the beatmaps that a shutdown kept from being synced; the next run
+// picks them up.
+func logInterrupted(beatmaps []gosynth.Beatmap, report *syncReport, log *slog.Logger) {
+	done := make(map[string]bool)
+	for _, result := range report.Results {
+		if result.Success {
+			done[result.Filename] = true
 		}
+	}
+	for _, name := range report.Skipped {
+		done[name] = true
+	}
 
-		devices = append(devices, Device{Serial: serial, Model: model})
+	var left []string
+	for _, bm := range beatmaps {
+		if !done[bm.Filename] {
+			left = append(left, bm.Filename)
+		}
+	}
+	if len(left) == 0 {
+		return
+	}
+	log.Warn(fmt.Sprintf("interrupted, %d beatmaps weren't synced and will be on the next run:", len(left)))
+	for _, name := range left {
+		log.Warn(fmt.Sprintf("  %s", name))
 	}
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+// fitFreeSpace compares the known sizes of the missing beatmaps with the free bytes on
+// the device; unknown sizes (-1) are left to the check as downloads start. If they don't
+// fit, it returns an error, or with --skip-on-full the beatmaps that fit, in order.
+func fitFreeSpace(missing []gosynth.Beatmap, sizes []int64, free int64, opts *options, log *slog.Logger) ([]gosynth.Beatmap, error) {
+	// Nothing fits on a full device, whatever the sizes
+	if free == 0 {
+		return nil, errors.New("the device is full; free up some space first")
 	}
 
-	return devices, nil
-}
+	var needed int64
+	for _, size := range sizes {
+		if size > 0 {
+			needed += size
+		}
+	}
+	log.Debug(fmt.Sprintf("Sync needs about %s; %s free on the device", gosynth.FormatBytes(needed), gosynth.FormatBytes(free)))
+	if needed <= free {
+		return missing, nil
+	}
 
-// selectDevice lets the user choose a device from the list and returns the serial number.
-func selectDevice(devices []Device) (string, error) {
-	if len(devices) == 0 {
-		return "", fmt.Errorf("no devices found")
+	log.Warn(fmt.Sprintf("the missing beatmaps need about %s but only %s is free on the device",
+		gosynth.FormatBytes(needed), gosynth.FormatBytes(free)))
+	if !opts.skipOnFull {
+		return nil, fmt.Errorf("not enough space on the device; free up %s or use --skip-on-full to sync as many as fit",
+			gosynth.FormatBytes(needed-free))
 	}
 
-	// Display devices
-	fmt.Println("Available devices:")
-	for i, device := range devices {
-		fmt.Printf("%d. Serial: %s, Model: %s\n", i+1, device.Serial, device.Model)
+	var fitting []gosynth.Beatmap
+	var used int64
+	for i, bm := range missing {
+		if sizes[i] < 0 || used+sizes[i] > free {
+			continue
+		}
+		used += sizes[i]
+		fitting = append(fitting, bm)
 	}
+	log.Info(fmt.Sprintf("Syncing the %d of %d missing beatmaps that fit (%s)",
+		len(fitting), len(missing), gosynth.FormatBytes(used)))
+	return fitting, nil
+}
 
-	// Prompt user for selection
-	fmt.Print("Enter the number of the device you want to select: ")
-	var choice int
-	_, err := fmt.Scanf("%d", &choice)
-	if err != nil || choice < 1 || choice > len(devices) {
-		return "", fmt.Errorf("invalid choice")
+// shouldRetry decides whether to retry failed beatmaps: always with --retry-failed,
+// otherwise only if the user agrees at an interactive prompt.
+func shouldRetry(opts *options, failed int) bool {
+	if opts.retryFailed {
+		return true
 	}
+	if opts.yes || !isTerminal(os.Stdin) {
+		return false
+	}
+	return confirm(fmt.Sprintf("Retry %d failed beatmaps?", failed))
+}
 
-	// Return the serial of the selected device
-	return devices[choice-1].Serial, nil
+// syncDevice compares one device against the catalog and pushes whatever it's missing.
+// listing caches the device's songs folder and may already be loaded. showProgress
+// enables the terminal progress bar.
+func syncDevice(ctx context.Context, adb gosynth.ADBClient, serial string, cat *catalog, listing *deviceFiles, opts *options, out *console, showProgress bool, log *slog.Logger) *syncReport {
+	plan := planDevice(ctx, adb, serial, cat, listing, opts, log)
+	if plan.done {
+		return plan.report
+	}
+	return pushDevice(ctx, adb, plan, cat, opts, out, showProgress, log)
+}
+
+// devicePlan is what a device sync decided to push, before anything is downloaded.
+type devicePlan struct {
+	serial  string
+	report  *syncReport
+	listing *deviceFiles
+	// missing are the beatmaps to push, after --pick, --limit, the size prompts and the
+	// free space check against the sizes known up front
+	missing []gosynth.Beatmap
+	// limited is set when some missing beatmaps were left for a later run
+	limited  bool
+	action   string
+	cacheDir string
+	// free is the number of bytes free on the device, or -1 if unknown
+	free int64
+	// done is set when there is nothing to push, e.g. after an error, a dry run or a
+	// declined prompt; report is final then
+	done bool
 }
 
-// listDeviceFolder lists the contents of a specified folder on the connected device.
-func listDeviceFolder(folderPath string, serial string) []string {
-	cmd := exec.Command("adb", "-s", serial, "shell", "ls", folderPath)
+// planDevice compares one device against the catalog and works out which beatmaps to
+// push, asking the user and checking the free space where needed.
+func planDevice(ctx context.Context, adb gosynth.ADBClient, serial string, cat *catalog, listing *deviceFiles, opts *options, log *slog.Logger) *devicePlan {
+	report := &syncReport{
+		Serial:      serial,
+		ServerTotal: cat.total,
+		Missing:     []string{},
+		Results:     []pushResult{},
+	}
+
+	// Make sure the songs folder exists; on a fresh install it may not yet
+	if err := gosynth.CheckDeviceFolder(ctx, adb, opts.remoteDir, serial); err != nil {
+		if opts.dryRun {
+			log.Warn(fmt.Sprintf("%v; it will be created when syncing", err))
+		} else {
+			log.Info(fmt.Sprintf("Creating %s on the device (use --remote-dir if this is the wrong folder)", opts.remoteDir))
+			if err := gosynth.MakeDeviceFolder(ctx, adb, opts.remoteDir, serial); err != nil {
+				log.Error(err.Error())
+				report.Error = err.Error()
+				return &devicePlan{report: report, done: true}
+			}
+		}
+	}
 
-	// Get the output of the adb command
-	output, err := cmd.Output()
+	// Get synth filenames from the device
+	// A failed listing would make everything look missing, so don't go on without one
+	files, err := listing.get(ctx)
 	if err != nil {
-		fmt.Printf("Error listing folder %s: %v\n", folderPath, err)
-		return nil
+		log.Error(err.Error())
+		report.Error = err.Error()
+		return &devicePlan{report: report, done: true}
+	}
+	log.Info(fmt.Sprintf("Found %d beatmaps on the device", len(files)))
+	report.DeviceCount = len(files)
+
+	// Work out which beatmaps the device doesn't have yet
+	wanted := sinceFilter(serial, cat.wanted, opts, log)
+	missing := gosynth.FindMissing(wanted, files)
+	report.Present = len(wanted) - len(missing)
+
+	// Beatmaps can be updated under the same filename; only their checksums tell
+	if opts.deepCompare && !opts.forcePush {
+		changed, unchecked := gosynth.FindChanged(ctx, adb, serial, opts.remoteDir, gosynth.FindPresent(wanted, files))
+		if unchecked > 0 {
+			log.Info(fmt.Sprintf("Compared %d beatmaps by name only, since their checksums aren't available", unchecked))
+		}
+		if len(changed) > 0 {
+			log.Info(fmt.Sprintf("%d beatmaps on the device differ from the server's and will be pushed again", len(changed)))
+			missing = append(missing, changed...)
+			slices.SortStableFunc(missing, func(a, b gosynth.Beatmap) int {
+				return strings.Compare(a.Filename, b.Filename)
+			})
+		}
+	}
+
+	// Report missing beatmaps
+	if len(missing) > 0 {
+		log.Info(fmt.Sprintf("Missing %d beatmaps on device:", len(missing)))
+		for _, bm := range missing {
+			log.Info(fmt.Sprintf("  %s", bm.Describe()))
+			log.Debug(fmt.Sprintf("  File: %s, Download URL: %s", bm.Filename, bm.DownloadUrl))
+		}
+	} else {
+		log.Info(fmt.Sprintf("✅ Everything up to date (%d beatmaps)", len(wanted)))
+	}
+	for _, bm := range missing {
+		report.Missing = append(report.Missing, bm.Filename)
 	}
 
-	// Split the output into lines and store them in a slice
-	lines := strings.Split(string(output), "\n")
+	// Push every wanted beatmap with --force-push, overwriting the copies on the device
+	action := "Pushed"
+	if opts.forcePush {
+		log.Info(fmt.Sprintf("Force-pushing %d beatmaps; %d already on the device will be overwritten",
+			len(wanted), len(wanted)-len(missing)))
+		missing = gosynth.FindMissing(wanted, nil)
+		action = "Force-pushed"
+	}
 
-	// Remove any empty lines at the end of the output
-	var nonEmptyLines []string
-	for _, line := range lines {
-		if strings.TrimSpace(line) != "" {
-			nonEmptyLines = append(nonEmptyLines, line)
+	// Remove maps that were taken down from the server
+	if opts.prune {
+		if err := pruneDevice(ctx, adb, serial, cat, listing, opts, log); err != nil {
+			log.Error(err.Error())
 		}
 	}
 
-	// Return the slice of lines
-	return nonEmptyLines
+	// Keep downloads in a local cache so reruns don't fetch them again
+	cacheDir := ""
+	if !opts.noCache {
+		var err error
+		cacheDir, err = gosynth.DefaultCacheDir()
+		if err != nil {
+			log.Warn(fmt.Sprintf("download cache disabled: %v", err))
+		} else {
+			log.Debug(fmt.Sprintf("Using download cache %s", cacheDir))
+		}
+	}
+
+	// In dry-run mode only report, with the download size if asked for
+	if opts.dryRun {
+		if opts.estimateSize && len(missing) > 0 {
+			sizes := gosynth.EstimateSizes(ctx, missing, cacheDir, opts.concurrency)
+			if opts.maxDownloadSize > 0 {
+				_, sizes = skipOversized(missing, sizes, int64(opts.maxDownloadSize), report)
+			}
+			logDownloadSize(sizes, log)
+			logSkipped(report, log)
+		}
+		return &devicePlan{report: report, done: true}
+	}
+
+	// Let the user choose which beatmaps to sync; the others stay missing
+	picked := false
+	if opts.pick && !opts.yes && isTerminal(os.Stdin) && len(missing) > 0 {
+		chosen := pickBeatmaps(missing)
+		picked = len(chosen) < len(missing)
+		missing = chosen
+	}
+
+	// Trickle in a fixed number of maps per run; missing is sorted, so reruns make progress
+	limited := picked || (opts.limit > 0 && len(missing) > opts.limit)
+	if opts.limit > 0 && len(missing) > opts.limit {
+		log.Info(fmt.Sprintf("Syncing %d of %d missing beatmaps; %d skipped due to --limit, run again for more",
+			opts.limit, len(missing), len(missing)-opts.limit))
+		missing = missing[:opts.limit]
+	}
+
+	free := int64(-1)
+	if len(missing) > 0 {
+		var err error
+		free, err = gosynth.FreeSpace(ctx, adb, serial, opts.remoteDir)
+		if err != nil {
+			log.Warn(fmt.Sprintf("couldn't check free space on the device: %v", err))
+			free = -1
+		}
+
+		// Looking up sizes costs a HEAD request per beatmap, so only do it when a decision
+		// has to be made before any download starts: the size prompt, or picking the
+		// beatmaps that fit with --skip-on-full. Otherwise the sizes of cached copies are
+		// all that's known, and downloads are checked against the free space as they start.
+		promptable := !opts.yes && isTerminal(os.Stdin)
+		sizes := make([]int64, len(missing))
+		for i, bm := range missing {
+			sizes[i] = -1
+			if size, ok := gosynth.CachedSize(bm, cacheDir); ok {
+				sizes[i] = size
+			}
+		}
+		estimate := (opts.skipOnFull && free >= 0) || (promptable && opts.confirmOver > 0)
+		if estimate {
+			sizes = gosynth.EstimateSizes(ctx, missing, cacheDir, opts.concurrency)
+		}
+		if opts.maxDownloadSize > 0 {
+			missing, sizes = skipOversized(missing, sizes, int64(opts.maxDownloadSize), report)
+		}
+		total := int64(-1)
+		if estimate {
+			total = logDownloadSize(sizes, log)
+		}
+
+		large := (opts.confirmOver > 0 && total > int64(opts.confirmOver)) ||
+			(opts.confirmCount > 0 && len(missing) > opts.confirmCount)
+		if large && promptable {
+			question := fmt.Sprintf("About to download %d beatmaps to %s. Continue?", len(missing), serial)
+			if total >= 0 {
+				question = fmt.Sprintf("About to download %d beatmaps (~%s) to %s. Continue?", len(missing), gosynth.FormatBytes(total), serial)
+			}
+			if !confirm(question) {
+				log.Info("Sync cancelled.")
+				return &devicePlan{report: report, done: true}
+			}
+		}
+
+		// Make sure the headset has room for the sizes known so far
+		if free >= 0 {
+			fitting, err := fitFreeSpace(missing, sizes, free, opts, log)
+			if err != nil {
+				log.Error(err.Error())
+				report.Error = err.Error()
+				return &devicePlan{report: report, done: true}
+			}
+			limited = limited || len(fitting) < len(missing)
+			missing = fitting
+		}
+	}
+
+	return &devicePlan{
+		serial:   serial,
+		report:   report,
+		listing:  listing,
+		missing:  missing,
+		limited:  limited,
+		action:   action,
+		cacheDir: cacheDir,
+		free:     free,
+	}
 }
 
-func downloadAndPushBeatmap(b Beatmap, serial string, remoteDir string) error {
-	// Step 1: Download the file
-	fullURL := "https://synthriderz.com" + b.DownloadUrl
-	resp, err := http.Get(fullURL)
-	if err != nil {
-		return fmt.Errorf("failed to download %s: %v", b.Filename, err)
+// pushDevice downloads and pushes the beatmaps a plan settled on and records a complete
+// sync in the device's manifest.
+func pushDevice(ctx context.Context, adb gosynth.ADBClient, plan *devicePlan, cat *catalog, opts *options, out *console, showProgress bool, log *slog.Logger) *syncReport {
+	serial, report, listing := plan.serial, plan.report, plan.listing
+	missing, limited, action, cacheDir := plan.missing, plan.limited, plan.action, plan.cacheDir
+
+	// Download missing beatmaps and upload to device
+	var hooks *hookQueue
+	if len(missing) > 0 {
+		// Hooks run outside OnResult, which Sync calls with its workers waiting
+		hooks = startHooks(ctx, opts.postPushHook, serial, log)
+		var retry []gosynth.Beatmap
+		noSpace := 0
+		progress := newSyncProgress(len(missing), action, "device at "+opts.remoteDir, out, showProgress, log)
+		syncOpts := gosynth.SyncOptions{
+			ADB:             adb,
+			Serial:          serial,
+			RemoteDir:       opts.remoteDir,
+			CacheDir:        cacheDir,
+			Workers:         opts.workers,
+			Stream:          opts.stream,
+			BatchSize:       opts.batchSize,
+			Unpack:          opts.unpack,
+			DownloadTimeout: opts.downloadTimeout,
+			MaxDownloadSize: int64(opts.maxDownloadSize),
+			FreeSpace:       plan.free,
+			TempDir:         opts.tempDir,
+			PerMapTimeout:   opts.perMapTimeout,
+			OnResult: func(result gosynth.BeatmapResult) {
+				progress.update(result)
+				// Beatmaps that turned out too big while downloading are skipped, not failed
+				if errors.Is(result.Err, gosynth.ErrTooLarge) {
+					report.Skipped = append(report.Skipped, result.Beatmap.Filename)
+					return
+				}
+				report.addResult(result)
+				// Retrying won't make room on the device
+				if errors.Is(result.Err, gosynth.ErrNoSpace) {
+					noSpace++
+					return
+				}
+				if result.Err != nil {
+					retry = append(retry, result.Beatmap)
+					return
+				}
+				for _, file := range listing.pushed(result) {
+					hooks.add(path.Join(opts.remoteDir, file))
+				}
+			},
+		}
+		// Per-file byte counts are only useful when someone is watching
+		if progress.tty {
+			syncOpts.DownloadProgress = out
+		}
+		succeeded, failed := gosynth.Sync(ctx, missing, syncOpts)
+		progress.finish()
+		summary := fmt.Sprintf("Sync complete: %d succeeded, %d failed", succeeded, failed)
+		if n := report.timedOut(); n > 0 {
+			summary += fmt.Sprintf(" (%d timed out)", n)
+		}
+		log.Info(summary)
+		if noSpace > 0 {
+			log.Error(fmt.Sprintf("%d beatmaps didn't fit into the space left on the device; free up some space or use --skip-on-full to sync as many as fit",
+				noSpace))
+		}
+
+		// Give failed beatmaps (e.g. from a flaky USB cable) one more chance
+		if len(retry) > 0 && ctx.Err() == nil && shouldRetry(opts, len(retry)) {
+			// The pushes so far used up some of the free space the plan started with
+			if syncOpts.FreeSpace > 0 {
+				if free, err := gosynth.FreeSpace(ctx, adb, serial, opts.remoteDir); err == nil {
+					// Zero would turn the check off
+					syncOpts.FreeSpace = max(free, 1)
+				}
+			}
+			var stillFailed []string
+			progress := newSyncProgress(len(retry), action, "device at "+opts.remoteDir, out, showProgress, log)
+			syncOpts.OnResult = func(result gosynth.BeatmapResult) {
+				report.addResult(result)
+				progress.update(result)
+				if result.Err != nil {
+					stillFailed = append(stillFailed, result.Beatmap.Filename)
+					return
+				}
+				for _, file := range listing.pushed(result) {
+					hooks.add(path.Join(opts.remoteDir, file))
+				}
+			}
+			gosynth.Sync(ctx, retry, syncOpts)
+			progress.finish()
+
+			if len(stillFailed) > 0 {
+				log.Error(fmt.Sprintf("%d beatmaps still failed after retrying:", len(stillFailed)))
+				for _, name := range stillFailed {
+					log.Error(fmt.Sprintf("  %s", name))
+				}
+			} else {
+				log.Info(fmt.Sprintf("All %d retried beatmaps synced.", len(retry)))
+			}
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed for %s: status %s", b.Filename, resp.Status)
+	hooks.wait()
+	logSkipped(report, log)
+	if ctx.Err() != nil {
+		logInterrupted(missing, report, log)
 	}
 
-	// Step 2: Save to a temp file
-	tmpPath := filepath.Join(os.TempDir(), b.Filename)
-	outFile, err := os.Create(tmpPath)
+	// Remember a complete sync so the next run can skip the comparison, and in any case
+	// which archives were unpacked so they don't look missing next time
+	if !limited && !cat.incomplete && report.failed() == 0 && ctx.Err() == nil {
+		// Post-push hooks may have changed the folder as well, so record what is there now
+		if len(missing) > 0 {
+			if _, err := listing.refresh(ctx); err != nil {
+				log.Warn(fmt.Sprintf("couldn't list the device again, recording the expected files: %v", err))
+			}
+		}
+		if err := saveManifest(serial, cat, listing, opts); err != nil {
+			log.Warn(fmt.Sprintf("failed to save sync manifest: %v", err))
+		}
+	} else if listing.unpackedChanged {
+		if err := saveUnpacked(serial, listing); err != nil {
+			log.Warn(fmt.Sprintf("failed to record unpacked beatmaps: %v", err))
+		}
+	}
+
+	return report
+}
+
+// setupLogging routes all output through a leveled logger and returns the console it writes to.
+func setupLogging(opts *options) *console {
+	level := slog.LevelInfo
+	switch {
+	case opts.verbose:
+		level = slog.LevelDebug
+	case opts.quiet:
+		level = slog.LevelError
+	}
+	// Keep stdout clean for the JSON report
+	logFile := os.Stdout
+	if opts.jsonOutput {
+		logFile = os.Stderr
+	}
+	out := &console{out: logFile, tty: isTerminal(logFile)}
+	slog.SetDefault(slog.New(newConsoleHandler(out, level)))
+	return out
+}
+
+// startADB starts the adb server, connects a wireless device if asked to, and lists the
+// devices adb knows about.
+func startADB(ctx context.Context, adb gosynth.ADBClient, opts *options) ([]gosynth.Device, error) {
+	// Start adb server
+	started := false
+	if gosynth.IsADBServerRunning() {
+		slog.Info("ADB server is already running.")
+	} else {
+		gosynth.StartADBServer(ctx, adb)
+		started = true
+	}
+
+	// Connect to a wireless device first; it becomes the default target
+	if opts.connect != "" {
+		if err := gosynth.ConnectWirelessDevice(ctx, adb, opts.connect, 15*time.Second); err != nil {
+			return nil, err
+		}
+		if opts.serial == "" && !opts.allDevices {
+			opts.serial = opts.connect
+		}
+	}
+
+	// List connected devices, giving a server that was just started time to find them
+	var devices []gosynth.Device
+	var err error
+	if started && opts.deviceWait > 0 {
+		slog.Debug(fmt.Sprintf("Waiting up to %v for devices to appear", opts.deviceWait))
+		devices, err = gosynth.WaitForDevices(ctx, adb, opts.deviceWait)
+	} else {
+		devices, err = gosynth.ListConnectedDevices(ctx, adb)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create file: %v", err)
+		return nil, fmt.Errorf("failed to list devices: %w", err)
 	}
-	defer outFile.Close()
+	return devices, nil
+}
 
-	_, err = io.Copy(outFile, resp.Body)
+// pickDevices starts adb, connects a wireless device if asked to, and returns the serials
+// of the devices to work on: every ready device with --all-devices, otherwise the one
+// given with --serial or chosen by the user.
+func pickDevices(ctx context.Context, adb gosynth.ADBClient, opts *options) ([]string, error) {
+	devices, err := startADB(ctx, adb, opts)
 	if err != nil {
-		return fmt.Errorf("failed to write file: %v", err)
+		return nil, err
 	}
 
-	// Step 3: Push to device
-	var cmd *exec.Cmd
-	if serial != "" {
-		cmd = exec.Command("adb", "-s", serial, "push", tmpPath, remoteDir)
+	// Narrow the devices down to the wanted model; a serial picks one directly
+	if opts.model != "" && opts.serial == "" {
+		matching := devicesByModel(devices, opts.model)
+		if len(matching) == 0 {
+			return nil, fmt.Errorf("%w: no connected device is a %s", gosynth.ErrDeviceNotFound, opts.model)
+		}
+		devices = matching
+	}
+	if opts.transportID != "" {
+		var matching []gosynth.Device
+		for _, device := range devices {
+			if device.TransportID == opts.transportID {
+				matching = append(matching, device)
+			}
+		}
+		if len(matching) == 0 {
+			return nil, fmt.Errorf("%w: no device has transport id %s (see gosynth devices)", gosynth.ErrDeviceNotFound, opts.transportID)
+		}
+		devices = matching
 	}
 
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("adb push failed: %v\nOutput: %s", err, string(output))
+	// Devices that adb sees but can't use yet are reported, not synced
+	var ready []gosynth.Device
+	for _, device := range devices {
+		if device.Ready() {
+			ready = append(ready, device)
+		} else {
+			slog.Warn(fmt.Sprintf("device %s is %s: %s", device.Serial, device.State, deviceStateHint(device.State)))
+		}
+	}
+	if len(ready) == 0 {
+		if len(devices) == 0 {
+			return nil, fmt.Errorf("no devices found\n%s", noDevicesHelp)
+		}
+		return nil, fmt.Errorf("no device is ready; see the warnings above")
 	}
 
-	fmt.Printf("✅ Pushed %s to device at %s\n", b.Filename, remoteDir)
+	if opts.allDevices {
+		var serials []string
+		for _, device := range ready {
+			gosynth.PinTransport(adb, device.Serial, device.TransportID)
+			serials = append(serials, device.Serial)
+		}
+		slog.Info(fmt.Sprintf("Using %d devices", len(serials)))
+		return serials, nil
+	}
 
-	// Step 4: Clean up
-	err = os.Remove(tmpPath)
+	serial, err := selectDevice(devices, opts.serial, opts.deviceIndex)
 	if err != nil {
-		fmt.Printf("⚠️ Warning: failed to delete temp file %s: %v\n", tmpPath, err)
+		return nil, fmt.Errorf("error selecting device: %w", err)
 	}
+	slog.Info(fmt.Sprintf("You selected device with Serial: %s", serial))
 
-	return nil
+	// Address the device by its connection for the rest of the run, so another device
+	// reporting the same serial can't get in the way
+	for _, device := range devices {
+		if device.Serial == serial {
+			gosynth.PinTransport(adb, serial, device.TransportID)
+			break
+		}
+	}
+	return []string{serial}, nil
 }
 
-func main() {
-	// Start adb server
-	if isAdbServerRunning() {
-		fmt.Println("ADB server is already running.")
-	} else {
-		startAdbServer()
+// defineSyncFlags registers the flags of the sync command.
+func defineSyncFlags(flags *flag.FlagSet, opts *options) {
+	flags.IntVar(&opts.concurrency, "concurrency", 4, "number of parallel page fetches, downloads and size lookups; pushes to a device always run one at a time")
+	flags.IntVar(&opts.workers, "workers", 0, "number of beatmaps to download concurrently (default --concurrency)")
+	flags.StringVar(&opts.serial, "serial", "", "serial of the device to sync (skips the interactive prompt)")
+	flags.StringVar(&opts.transportID, "transport-id", "", "sync the device with this adb transport id (see gosynth devices)")
+	flags.IntVar(&opts.deviceIndex, "device-index", 0, "sync the Nth device, numbered like in the device prompt (skips the prompt)")
+	flags.StringVar(&opts.model, "model", "", "sync the device of this model, e.g. \"Quest 3\" (prompts if several match)")
+	flags.StringVar(&opts.connect, "connect", "", "connect to a wireless ADB device at host:port before syncing")
+	flags.StringVar(&opts.remoteDir, "remote-dir", gosynth.DefaultRemoteDir, "CustomSongs folder on the device")
+	flags.StringVar(&opts.adbPath, "adb-path", "", "adb binary to run (default $ADB, then adb on PATH)")
+	flags.DurationVar(&opts.deviceWait, "device-wait", gosynth.DefaultDeviceWait, "how long to wait for devices after starting the ADB server (0 = don't wait)")
+	flags.BoolVar(&opts.noCache, "no-cache", false, "don't keep downloaded beatmaps in the local cache")
+	flags.StringVar(&opts.tempDir, "temp-dir", "", "folder for temporary downloads (default the system temp folder)")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "report missing beatmaps without downloading; exits 2 if any are missing")
+	flags.IntVar(&opts.limit, "limit", 0, "sync at most N missing beatmaps this run, in filename order (0 = no limit)")
+	flags.BoolVar(&opts.prune, "prune", false, "delete beatmaps from the device that are no longer on the server")
+	flags.BoolVar(&opts.yes, "yes", false, "don't ask for confirmation")
+	flags.BoolVar(&opts.verbose, "verbose", false, "show debug output")
+	flags.BoolVar(&opts.quiet, "quiet", false, "only show errors")
+	flags.Var(&opts.difficulties, "difficulty", "only sync beatmaps with this difficulty (repeatable, e.g. Expert)")
+	flags.Float64Var(&opts.minRating, "min-rating", 0, "only sync beatmaps with a difficulty rated at least this many stars")
+	flags.Float64Var(&opts.maxRating, "max-rating", 0, "only sync beatmaps with a difficulty rated at most this many stars (0 = no limit)")
+	flags.BoolVar(&opts.requireRating, "require-rating", false, "skip beatmaps without star ratings instead of syncing them")
+	flags.BoolVar(&opts.jsonOutput, "json", false, "print a JSON report on stdout; all other output goes to stderr")
+	flags.BoolVar(&opts.quietPages, "quiet-pages", false, "don't show progress while fetching the catalog, only the summary")
+	flags.BoolVar(&opts.allDevices, "all-devices", false, "sync every connected device instead of selecting one")
+	flags.IntVar(&opts.parallelDevices, "parallel-devices", 2, "number of devices --all-devices syncs at the same time; they share downloads through the cache")
+	flags.BoolVar(&opts.stream, "stream", false, "pipe downloads straight to the device without a temp file")
+	flags.StringVar(&opts.outputDir, "output-dir", "", "download the beatmaps to this folder instead of a device (no adb needed)")
+	flags.StringVar(&opts.localDir, "local-dir", "", "compare against the beatmaps in this folder instead of a device; missing ones go to --output-dir (default this folder)")
+	flags.BoolVar(&opts.unpack, "unpack", false, "extract .zip beatmaps and push their contents instead of the archive")
+	flags.IntVar(&opts.batchSize, "batch-size", 0, "download N beatmaps at a time into a staging folder and push them with one adb call (0 = push each file)")
+	flags.DurationVar(&opts.timeout, "timeout", gosynth.DefaultAPITimeout, "timeout for each API page request, e.g. 30s")
+	flags.DurationVar(&opts.downloadTimeout, "download-timeout", gosynth.DefaultDownloadTimeout, "time limit for downloading a single beatmap, e.g. 10m")
+	flags.DurationVar(&opts.deadline, "deadline", 0, "stop syncing after this long, e.g. 30m, and exit 4; the rest is synced on the next run (0 = no limit)")
+	flags.DurationVar(&opts.perMapTimeout, "per-map-timeout", 0, "give up on a beatmap whose download and push together take longer than this, e.g. 15m (0 = no limit)")
+	flags.BoolVar(&opts.retryFailed, "retry-failed", false, "retry failed beatmaps once at the end without asking")
+	flags.BoolVar(&opts.force, "force", false, "ignore the sync manifest and always compare the full catalog")
+	flags.StringVar(&opts.postPushHook, "post-push-hook", "", "shell command to run after each pushed beatmap; gets the filename and remote path as $1 and $2 and as GOSYNTH_FILENAME and GOSYNTH_REMOTE_PATH, plus GOSYNTH_SERIAL")
+	flags.BoolVar(&opts.pick, "pick", false, "choose which missing beatmaps to sync from a numbered list (ignored with --yes or without a terminal)")
+	flags.BoolVar(&opts.notify, "notify", false, "show a desktop notification when the sync finishes")
+	flags.DurationVar(&opts.watch, "watch", 0, "keep running and sync again every interval, e.g. 30m, until interrupted (0 = sync once)")
+	flags.BoolVar(&opts.recursive, "recursive", false, "also count beatmaps in subfolders of --remote-dir as present")
+	flags.BoolVar(&opts.forcePush, "force-push", false, "push every wanted beatmap, overwriting the ones already on the device (combine with --include to re-push a few)")
+	flags.BoolVar(&opts.deepCompare, "deep-compare", false, "also checksum the beatmaps on the device and re-push the ones whose content differs from the server's (slower)")
+	flags.DurationVar(&opts.manifestTTL, "manifest-ttl", 24*time.Hour, "how long a device counts as up to date after a complete sync")
+	flags.StringVar(&opts.query.Search, "search", "", "only sync beatmaps matching this API text search")
+	flags.StringVar(&opts.query.Mapper, "mapper", "", "only sync beatmaps by this mapper")
+	flags.StringVar(&opts.query.Sort, "sort", "", "API sort order, e.g. published_at,DESC")
+	flags.IntVar(&opts.query.PageSize, "page-size", 0, fmt.Sprintf("beatmaps per API page, up to %d; larger pages need fewer requests (0 = API default)", gosynth.MaxPageSize))
+	flags.StringVar(&opts.since, "since", "", "only sync beatmaps added after a date (2024-05-01), a duration ago (7d, 48h), or 'last' for the previous sync")
+	flags.Var(&opts.include, "include", "only sync beatmaps whose filename matches this glob (repeatable, e.g. 'Pack*')")
+	flags.Var(&opts.exclude, "exclude", "skip beatmaps whose filename matches this glob (repeatable; wins over --include)")
+	opts.confirmOver = 1 << 30
+	flags.Var(&opts.confirmOver, "confirm-over", "ask before downloading more than this, e.g. 500MB (0 = never ask)")
+	flags.IntVar(&opts.confirmCount, "confirm-count", 200, "ask before downloading more than this many beatmaps (0 = never ask)")
+	flags.Var(&opts.maxDownloadSize, "max-download-size", "skip beatmaps bigger than this, e.g. 50MB (0 = no limit)")
+	flags.BoolVar(&opts.estimateSize, "estimate-size", false, "with --dry-run, also report the download size")
+	flags.Float64Var(&opts.rateLimit, "rate-limit", gosynth.DefaultRateLimit, "maximum requests per second to synthriderz.com (0 = unlimited)")
+	defineServerFlags(flags, opts)
+	flags.BoolVar(&opts.skipOnFull, "skip-on-full", false, "if the device is short on space, sync as many beatmaps as fit instead of aborting")
+}
+
+// runSync implements `gosynth sync`, the default command: it pushes every beatmap the
+// selected devices are missing.
+func runSync(ctx context.Context, opts *options, stdout *console) int {
+	if opts.concurrency < 1 {
+		slog.Error("--concurrency must be at least 1")
+		return exitFatal
+	}
+	if opts.workers < 0 {
+		slog.Error("--workers must not be negative")
+		return exitFatal
+	}
+	if opts.workers == 0 {
+		opts.workers = opts.concurrency
+	}
+	if opts.limit < 0 {
+		slog.Error("--limit must not be negative")
+		return exitFatal
+	}
+	if opts.batchSize < 0 {
+		slog.Error("--batch-size must not be negative")
+		return exitFatal
 	}
+	if opts.batchSize > 0 && (opts.stream || opts.unpack) {
+		slog.Error("--batch-size can't be combined with --stream or --unpack")
+		return exitFatal
+	}
+	if opts.timeout <= 0 || opts.downloadTimeout <= 0 {
+		slog.Error("--timeout and --download-timeout must be positive durations")
+		return exitFatal
+	}
+	if opts.rateLimit < 0 {
+		slog.Error("--rate-limit must not be negative")
+		return exitFatal
+	}
+	if opts.query.PageSize < 0 || opts.query.PageSize > gosynth.MaxPageSize {
+		slog.Error(fmt.Sprintf("--page-size must be between 0 and %d", gosynth.MaxPageSize))
+		return exitFatal
+	}
+	if _, err := gosynth.FilterByName(nil, opts.include, opts.exclude); err != nil {
+		slog.Error(err.Error())
+		return exitFatal
+	}
+	if opts.since != "" && opts.since != "last" {
+		if _, err := parseSince(opts.since, time.Now()); err != nil {
+			slog.Error(err.Error())
+			return exitFatal
+		}
+	}
+	if opts.minRating < 0 || opts.maxRating < 0 {
+		slog.Error("--min-rating and --max-rating must not be negative")
+		return exitFatal
+	}
+	if opts.maxRating > 0 && opts.maxRating < opts.minRating {
+		slog.Error("--max-rating must not be below --min-rating")
+		return exitFatal
+	}
+	if opts.confirmCount < 0 {
+		slog.Error("--confirm-count must not be negative")
+		return exitFatal
+	}
+	if opts.deadline < 0 {
+		slog.Error("--deadline must not be negative")
+		return exitFatal
+	}
+	if opts.parallelDevices < 1 {
+		slog.Error("--parallel-devices must be at least 1")
+		return exitFatal
+	}
+	if opts.perMapTimeout < 0 {
+		slog.Error("--per-map-timeout must not be negative")
+		return exitFatal
+	}
+	if opts.watch < 0 {
+		slog.Error("--watch must not be negative")
+		return exitFatal
+	}
+	gosynth.SetAPITimeout(opts.timeout)
+	gosynth.SetRateLimit(opts.rateLimit)
+	if opts.outputDir == "" {
+		opts.outputDir = opts.localDir
+	}
+	// Find a usable temp folder now rather than failing every download later
+	if opts.outputDir == "" && !opts.dryRun {
+		dir, err := resolveTempDir(opts)
+		if err != nil {
+			slog.Error(err.Error())
+			return exitFatal
+		}
+		opts.tempDir = dir
+	}
+
+	run := syncOnce
+	if opts.watch > 0 {
+		run = watchSync
+	}
+	if opts.deadline == 0 {
+		return run(ctx, opts, stdout)
+	}
+
+	// Stop starting new work once the deadline passes; pushes in flight get to finish
+	deadlineCtx, cancel := context.WithTimeout(ctx, opts.deadline)
+	defer cancel()
+	code := run(deadlineCtx, opts, stdout)
+	if code != exitOK && errors.Is(deadlineCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		slog.Warn(fmt.Sprintf("stopped after the --deadline of %v; the rest will be synced on the next run", opts.deadline))
+		return exitDeadline
+	}
+	return code
+}
 
-	// List connected devices
-	devices, err := listConnectedDevices()
+// resolveTempDir returns the folder for temporary downloads: --temp-dir, which must be
+// writable, or else the system temp folder, falling back to the download cache if that
+// one isn't writable.
+func resolveTempDir(opts *options) (string, error) {
+	if opts.tempDir != "" {
+		if err := checkWritable(opts.tempDir); err != nil {
+			return "", fmt.Errorf("--temp-dir %s is not writable: %w", opts.tempDir, err)
+		}
+		return opts.tempDir, nil
+	}
+
+	dir := os.TempDir()
+	err := checkWritable(dir)
+	if err == nil {
+		return dir, nil
+	}
+	if !opts.noCache {
+		if cacheDir, cacheErr := gosynth.DefaultCacheDir(); cacheErr == nil && checkWritable(cacheDir) == nil {
+			slog.Warn(fmt.Sprintf("temp folder %s is not writable, using %s instead", dir, cacheDir))
+			return cacheDir, nil
+		}
+	}
+	return "", fmt.Errorf("temp folder %s is not writable: %w; use --temp-dir", dir, err)
+}
+
+// watchSync implements --watch: it syncs every interval until interrupted, and then
+// returns exitCancelled. Devices are looked up again each cycle, so a headset that was
+// unplugged is synced once it's back, and the sync manifest keeps cycles where nothing
+// changed cheap.
+func watchSync(ctx context.Context, opts *options, stdout *console) int {
+	for {
+		if code := syncOnce(ctx, opts, stdout); code == exitCancelled {
+			return code
+		}
+		slog.Info(fmt.Sprintf("Next sync at %s", time.Now().Add(opts.watch).Format("15:04:05")))
+
+		timer := time.NewTimer(opts.watch)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			slog.Info("Stopped watching.")
+			return exitCancelled
+		case <-timer.C:
+		}
+	}
+}
+
+// syncOnce runs a single sync of the selected devices, or of the output folder.
+func syncOnce(ctx context.Context, opts *options, stdout *console) int {
+	// Without a device, just download into the output folder
+	if opts.outputDir != "" {
+		return downloadToDir(ctx, opts, stdout)
+	}
+
+	adb, err := newADB(opts)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		slog.Error(err.Error())
+		return exitFatal
 	}
 
-	// Let the user select a device
-	serial, err := selectDevice(devices)
+	serials, err := pickDevices(ctx, adb, opts)
 	if err != nil {
-		fmt.Printf("Error selecting device: %v\n", err)
-		return
+		slog.Error(err.Error())
+		return exitFatal
+	}
+	// Keep syncing the same headset in --watch mode instead of asking every time
+	if opts.watch > 0 && !opts.allDevices {
+		opts.serial = serials[0]
 	}
 
-	// Print the selected device's serial
-	fmt.Printf("You selected device with Serial: %s\n", serial)
+	// Fetch beatmaps from synthriderz.com api; the first page tells us if anything changed
+	start := time.Now()
+	firstPage, err := gosynth.FetchPage(ctx, 1, opts.query)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error fetching beatmaps: %v", err))
+		return exitFatal
+	}
 
-	// Get synth filenames from the device
-	files := listDeviceFolder("/sdcard/SynthRidersUC/CustomSongs/", serial)
+	// Skip devices whose manifest shows they're already in sync
+	reports := make([]*syncReport, len(serials))
+	var pending []int
+	for i, serial := range serials {
+		if reports[i] = upToDateReport(serial, firstPage, opts, slog.Default()); reports[i] == nil {
+			pending = append(pending, i)
+		}
+	}
 
-	count := len(files)
-	fmt.Printf("The number of items in the slice is: %d\n", count)
+	var cat *catalog
+	if len(pending) > 0 {
+		cat = fetchCatalog(ctx, firstPage, opts, stdout)
+	}
+	catalogTime := time.Since(start)
 
-	// Fetch beatmaps from synthriderz.com api
-	firstPage := fetchPage(1)
-	start := time.Now()
+	// Sync each device; with several devices, tag their output and skip the progress bar
+	if len(serials) == 1 {
+		if len(pending) == 1 {
+			showProgress := !opts.quiet && stdout.tty
+			listing := newDeviceFiles(adb, serials[0], opts)
+			reports[0] = syncDevice(ctx, adb, serials[0], cat, listing, opts, stdout, showProgress, slog.Default())
+		}
+	} else {
+		// Settle what each device gets, prompts and space checks included, before anything
+		// is downloaded
+		plans := make([]*devicePlan, len(serials))
+		forEachDevice(pending, opts.parallelDevices, func(i int) {
+			log := slog.With("device", serials[i])
+			listing := newDeviceFiles(adb, serials[i], opts)
+			plans[i] = planDevice(ctx, adb, serials[i], cat, listing, opts, log)
+		})
 
-	allPages := fetchAllPagesConcurrently(firstPage.PageCount)
+		// Download what several devices miss only once
+		prefetchShared(ctx, plans, opts, stdout)
 
-	fmt.Printf("Execution time: %v\n", time.Since(start))
-	for _, page := range allPages {
-		fmt.Printf("Processed page %d with %d beatmaps\n", page.Page, len(page.Data))
+		forEachDevice(pending, opts.parallelDevices, func(i int) {
+			log := slog.With("device", serials[i])
+			if plans[i].done {
+				reports[i] = plans[i].report
+				return
+			}
+			reports[i] = pushDevice(ctx, adb, plans[i], cat, opts, stdout, false, log)
+		})
+
+		for _, report := range reports {
+			slog.Info(fmt.Sprintf("%s: %d on device, %d missing, %d pushed, %d failed",
+				report.Serial, report.DeviceCount, len(report.Missing), report.succeeded(), report.failed()))
+		}
+	}
+	if !opts.dryRun && len(pending) > 0 {
+		logTransferStats(catalogTime, time.Since(start))
+		logSummary(reports)
 	}
 
-	// Step 1: Convert device files to a map for fast lookup
-	deviceFilesMap := make(map[string]bool)
-	for _, file := range files {
-		deviceFilesMap[file] = true
+	if opts.jsonOutput {
+		var err error
+		if len(reports) == 1 {
+			err = writeJSON(reports[0])
+		} else {
+			err = writeJSON(reports)
+		}
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to write JSON report: %v", err))
+		}
 	}
 
-	// Step 2: Loop through all beatmaps and check if each filename exists on the device
-	var missing []Beatmap
+	code := exitCode(ctx, reports, opts.dryRun)
+	if opts.notify && (opts.watch == 0 || synced(reports)) {
+		notifyResult(ctx, reports, code, opts.dryRun)
+	}
+	return code
+}
 
-	for _, page := range allPages {
-		for _, beatmap := range page.Data {
-			if !deviceFilesMap[beatmap.Filename] {
-				missing = append(missing, beatmap)
-			}
+// forEachDevice calls fn for each of the device indexes, running up to parallel calls at once.
+func forEachDevice(indexes []int, parallel int, fn func(i int)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallel)
+	for _, i := range indexes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
+
+// synced reports whether any beatmap was pushed or failed to.
+func synced(reports []*syncReport) bool {
+	for _, report := range reports {
+		if len(report.Results) > 0 {
+			return true
 		}
 	}
+	return false
+}
 
-	// Step 3: Report missing beatmaps
-	if len(missing) > 0 {
-		fmt.Printf("\nMissing %d beatmaps on device:\n", len(missing))
-		for _, bm := range missing {
-			fmt.Printf("Filename: %s\nDownload URL: %s\n\n", bm.Filename, bm.DownloadUrl)
+// exitCode derives the exit status of a sync from its reports. In dry-run mode it tells
+// scripts whether anything is missing.
+func exitCode(ctx context.Context, reports []*syncReport, dryRun bool) int {
+	if ctx.Err() != nil {
+		return exitCancelled
+	}
+	for _, report := range reports {
+		if report.Error != "" || report.failed() > 0 || (dryRun && len(report.Missing) > 0) {
+			return exitFailures
 		}
-	} else {
-		fmt.Println("\nAll beatmaps are present on the device.")
 	}
+	return exitOK
+}
 
-	// Download missing beatmaps and upload to device
-	remoteDir := "/sdcard/SynthRidersUC/CustomSongs/"
+func main() {
+	// The command name is optional and defaults to sync
+	name, args := "sync", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage(os.Stdout)
+		return
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage(os.Stderr)
+		os.Exit(exitFatal)
+	}
 
-	for _, bm := range missing {
-		err := downloadAndPushBeatmap(bm, serial, remoteDir)
-		if err != nil {
-			fmt.Printf("❌ Error processing %s: %v\n", bm.Filename, err)
+	opts := &options{}
+	flags := flag.NewFlagSet("gosynth "+cmd.name, flag.ContinueOnError)
+	cmd.define(flags, opts)
+	configPath := flags.String("config", "", "config file with default flag values (default ~/.config/gosynth/config.yaml)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: gosynth %s [flags]\n\n%s\n\nFlags:\n", cmd.name, cmd.summary)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(exitOK)
 		}
+		os.Exit(exitFatal)
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected argument %q\n", flags.Arg(0))
+		flags.Usage()
+		os.Exit(exitFatal)
+	}
+
+	// Fill in flags that weren't given from the config file
+	configErr := loadConfig(flags, *configPath)
+
+	stdout := setupLogging(opts)
+	if configErr != nil {
+		slog.Error(configErr.Error())
+		os.Exit(exitFatal)
+	}
+	emptyToken := false
+	flags.Visit(func(f *flag.Flag) {
+		emptyToken = emptyToken || (f.Name == "api-token" && opts.apiToken == "")
+	})
+	if emptyToken {
+		slog.Error("--api-token must not be empty")
+		os.Exit(exitFatal)
+	}
+	server, err := serverConfig(opts)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitFatal)
 	}
+	gosynth.SetServerConfig(server)
+	gosynth.SetUserAgent(opts.userAgent)
 
+	// Cancel outstanding requests and pushes on Ctrl+C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := cmd.run(ctx, opts, stdout)
+	stop()
+	os.Exit(code)
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// notifier shows a desktop notification.
+type notifier interface {
+	notify(ctx context.Context, title string, message string) error
+}
+
+// commandNotifier shows notifications by running a program; the title and message are
+// passed as arguments or, for scripts, as GOSYNTH_TITLE and GOSYNTH_MESSAGE.
+type commandNotifier struct {
+	name string
+	args []string
+	// withText appends the title and message to args
+	withText bool
+}
+
+func (n commandNotifier) notify(ctx context.Context, title string, message string) error {
+	args := n.args
+	if n.withText {
+		args = append(append([]string{}, args...), title, message)
+	}
+	cmd := exec.CommandContext(ctx, n.name, args...)
+	cmd.Env = append(os.Environ(), "GOSYNTH_TITLE="+title, "GOSYNTH_MESSAGE="+message)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", n.name, err, output)
+	}
+	return nil
+}
+
+// windowsToast shows a toast through the WinRT notification API, which PowerShell can
+// reach without any extra module.
+const windowsToast = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$texts = $template.GetElementsByTagName('text')
+$texts.Item(0).AppendChild($template.CreateTextNode($env:GOSYNTH_TITLE)) > $null
+$texts.Item(1).AppendChild($template.CreateTextNode($env:GOSYNTH_MESSAGE)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('gosynth').Show([Windows.UI.Notifications.ToastNotification]::new($template))`
+
+// desktopNotifier returns the notifier for this platform, or nil if its tool isn't installed.
+func desktopNotifier() notifier {
+	var n commandNotifier
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		n = commandNotifier{name: "notify-send", withText: true}
+	case "darwin":
+		n = commandNotifier{name: "osascript", withText: true, args: []string{
+			"-e", "on run argv",
+			"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+			"-e", "end run",
+		}}
+	case "windows":
+		n = commandNotifier{name: "powershell", args: []string{"-NoProfile", "-NonInteractive", "-Command", windowsToast}}
+	default:
+		return nil
+	}
+	if _, err := exec.LookPath(n.name); err != nil {
+		return nil
+	}
+	return n
+}
+
+// notifyTimeout caps how long showing a notification may take
+const notifyTimeout = 10 * time.Second
+
+// notifyResult implements --notify: it sends a desktop notification summarizing the sync,
+// or logs the summary if no notifier is available.
+func notifyResult(ctx context.Context, reports []*syncReport, code int, dryRun bool) {
+	pushed, failed, missing := 0, 0, 0
+	for _, report := range reports {
+		pushed += report.succeeded()
+		failed += report.failed()
+		missing += len(report.Missing)
+	}
+
+	var message string
+	switch {
+	case dryRun:
+		message = fmt.Sprintf("%d beatmaps missing", missing)
+	case code == exitCancelled:
+		message = fmt.Sprintf("Sync interrupted after %d beatmaps", pushed)
+	case failed > 0:
+		message = fmt.Sprintf("Synced %d beatmaps, %d failed", pushed, failed)
+	default:
+		message = fmt.Sprintf("Synced %d beatmaps", pushed)
+	}
+
+	n := desktopNotifier()
+	if n == nil {
+		slog.Info(message)
+		return
+	}
+	// Notify even when the sync itself was cancelled
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), notifyTimeout)
+	defer cancel()
+	if err := n.notify(ctx, "GoSynth", message); err != nil {
+		slog.Debug(fmt.Sprintf("desktop notification failed: %v", err))
+		slog.Info(message)
+	}
+}
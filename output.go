@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/ninjaki8/GoSynth/gosynth"
+)
+
+// downloadToDir implements `gosynth sync --output-dir`: it downloads the wanted beatmaps
+// into a local folder without touching adb or any device. With --local-dir, only the
+// beatmaps missing from that folder are downloaded.
+func downloadToDir(ctx context.Context, opts *options, out *console) int {
+	if err := os.MkdirAll(opts.outputDir, 0o755); err != nil {
+		slog.Error(fmt.Sprintf("Failed to create %s: %v", opts.outputDir, err))
+		return exitFatal
+	}
+
+	start := time.Now()
+	firstPage, err := gosynth.FetchPage(ctx, 1, opts.query)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error fetching beatmaps: %v", err))
+		return exitFatal
+	}
+	cat := fetchCatalog(ctx, firstPage, opts, out)
+	catalogTime := time.Since(start)
+	wanted := sinceFilter("", cat.wanted, opts, slog.Default())
+
+	// Skip what the local folder already has
+	all := len(wanted)
+	if opts.localDir != "" {
+		files, err := gosynth.ListLocalSongs(opts.localDir)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to list %s: %v", opts.localDir, err))
+			return exitFatal
+		}
+		slog.Info(fmt.Sprintf("Found %d beatmaps in %s", len(files), opts.localDir))
+		wanted = gosynth.FindMissing(wanted, files)
+	}
+
+	report := &syncReport{
+		OutputDir:   opts.outputDir,
+		ServerTotal: cat.total,
+		Missing:     []string{},
+		Results:     []pushResult{},
+	}
+	report.Present = all - len(wanted)
+	for _, bm := range wanted {
+		report.Missing = append(report.Missing, bm.Filename)
+	}
+	slog.Info(fmt.Sprintf("%d beatmaps to download to %s", len(wanted), opts.outputDir))
+	for _, bm := range wanted {
+		slog.Debug(fmt.Sprintf("  %s", bm.Describe()))
+	}
+
+	if opts.limit > 0 && len(wanted) > opts.limit {
+		slog.Info(fmt.Sprintf("Downloading %d of %d beatmaps; %d skipped due to --limit, run again for more",
+			opts.limit, len(wanted), len(wanted)-opts.limit))
+		wanted = wanted[:opts.limit]
+	}
+
+	if !opts.dryRun && len(wanted) > 0 {
+		progress := newSyncProgress(len(wanted), "Saved", opts.outputDir, out, !opts.quiet && out.tty, slog.Default())
+		syncOpts := gosynth.SyncOptions{
+			OutputDir:       opts.outputDir,
+			Workers:         opts.workers,
+			DownloadTimeout: opts.downloadTimeout,
+			PerMapTimeout:   opts.perMapTimeout,
+			Unpack:          opts.unpack,
+			MaxDownloadSize: int64(opts.maxDownloadSize),
+			OnResult: func(result gosynth.BeatmapResult) {
+				progress.update(result)
+				if errors.Is(result.Err, gosynth.ErrTooLarge) {
+					report.Skipped = append(report.Skipped, result.Beatmap.Filename)
+					return
+				}
+				report.addResult(result)
+			},
+		}
+		if progress.tty {
+			syncOpts.DownloadProgress = out
+		}
+		succeeded, failed := gosynth.Sync(ctx, wanted, syncOpts)
+		progress.finish()
+		slog.Info(fmt.Sprintf("Download complete: %d succeeded, %d failed", succeeded, failed))
+		logTransferStats(catalogTime, time.Since(start))
+		logSummary([]*syncReport{report})
+		logSkipped(report, slog.Default())
+		if ctx.Err() != nil {
+			logInterrupted(wanted, report, slog.Default())
+		}
+	}
+
+	if opts.jsonOutput {
+		if err := writeJSON(report); err != nil {
+			slog.Error(fmt.Sprintf("Failed to write JSON report: %v", err))
+		}
+	}
+	reports := []*syncReport{report}
+	code := exitCode(ctx, reports, opts.dryRun)
+	if opts.notify && (opts.watch == 0 || synced(reports)) {
+		notifyResult(ctx, reports, code, opts.dryRun)
+	}
+	return code
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ninjaki8/GoSynth/gosynth"
+)
+
+// pickBeatmaps implements --pick: it lists the beatmaps and lets the user toggle which
+// ones to sync by number or range (e.g. "3 5-9"), or with "all" and "none", until an
+// empty line confirms the selection. The menu is shown on stderr. The picked beatmaps are returned in order.
+func pickBeatmaps(beatmaps []gosynth.Beatmap) []gosynth.Beatmap {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	selected := make([]bool, len(beatmaps))
+	in := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprintln(os.Stderr, "Missing beatmaps:")
+		count := 0
+		for i, bm := range beatmaps {
+			mark := " "
+			if selected[i] {
+				mark = "x"
+				count++
+			}
+			fmt.Fprintf(os.Stderr, "  [%s] %d. %s\n", mark, i+1, bm.Describe())
+		}
+		fmt.Fprintf(os.Stderr, "%d of %d selected. Toggle numbers or ranges (e.g. 1 3 5-9), \"all\" or \"none\"; press Enter to sync: ", count, len(beatmaps))
+
+		line, err := in.ReadString('\n')
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line == "" || err != nil {
+			break
+		}
+		switch line {
+		case "all":
+			for i := range selected {
+				selected[i] = true
+			}
+		case "none":
+			clear(selected)
+		default:
+			indexes, err := parseSelection(line, len(beatmaps))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				continue
+			}
+			for _, i := range indexes {
+				selected[i] = !selected[i]
+			}
+		}
+	}
+
+	var picked []gosynth.Beatmap
+	for i, bm := range beatmaps {
+		if selected[i] {
+			picked = append(picked, bm)
+		}
+	}
+	return picked
+}
+
+// parseSelection turns numbers and ranges such as "1,3 5-9" into indexes into a list of
+// n items.
+func parseSelection(input string, n int) ([]int, error) {
+	var indexes []int
+	for _, field := range strings.FieldsFunc(input, func(r rune) bool { return r == ',' || r == ' ' }) {
+		from, to, isRange := strings.Cut(field, "-")
+		first, err := strconv.Atoi(from)
+		last := first
+		if err == nil && isRange {
+			last, err = strconv.Atoi(to)
+		}
+		if err != nil || first < 1 || last > n || first > last {
+			return nil, fmt.Errorf("invalid selection %q: use numbers from 1 to %d", field, n)
+		}
+		for i := first; i <= last; i++ {
+			indexes = append(indexes, i-1)
+		}
+	}
+	return indexes, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/ninjaki8/GoSynth/gosynth"
+)
+
+// prefetchShared downloads the beatmaps that several devices are about to push into the
+// cache once, before the devices are synced, so each device pushes the cached copy instead
+// of downloading it again. Only beatmaps the device plans settled on are downloaded, after
+// their prompts and free space checks; beatmaps that fail here are simply downloaded again
+// by the devices.
+func prefetchShared(ctx context.Context, plans []*devicePlan, opts *options, out *console) {
+	if opts.noCache || opts.stream {
+		return
+	}
+	cacheDir, err := gosynth.DefaultCacheDir()
+	if err != nil {
+		return
+	}
+
+	// Work out which devices need each beatmap
+	needed := make(map[string]int)
+	var union []gosynth.Beatmap
+	devices := 0
+	for _, plan := range plans {
+		if plan == nil || plan.done {
+			continue
+		}
+		devices++
+		for _, bm := range plan.missing {
+			if needed[bm.Filename] == 0 {
+				union = append(union, bm)
+			}
+			needed[bm.Filename]++
+		}
+	}
+
+	shared := 0
+	for _, count := range needed {
+		if count > 1 {
+			shared++
+		}
+	}
+	if shared == 0 {
+		return
+	}
+
+	slog.Info(fmt.Sprintf("Downloading %d beatmaps once for %d devices", len(union), devices))
+	progress := newSyncProgress(len(union), "Downloaded", "the cache", out, !opts.quiet && out.tty, slog.Default())
+	fetched := make(map[string]bool)
+	syncOpts := gosynth.SyncOptions{
+		OutputDir:       cacheDir,
+		Workers:         opts.workers,
+		DownloadTimeout: opts.downloadTimeout,
+		PerMapTimeout:   opts.perMapTimeout,
+		MaxDownloadSize: int64(opts.maxDownloadSize),
+		OnResult: func(result gosynth.BeatmapResult) {
+			progress.update(result)
+			if result.Err == nil {
+				fetched[result.Beatmap.Filename] = true
+			}
+		},
+	}
+	if progress.tty {
+		syncOpts.DownloadProgress = out
+	}
+	_, failed := gosynth.Sync(ctx, union, syncOpts)
+	progress.finish()
+	if failed > 0 {
+		slog.Warn(fmt.Sprintf("%d shared downloads failed; the devices will try them again", failed))
+	}
+
+	// Each device that needs a beatmap after the first one would have downloaded it again
+	saved, savedBytes := 0, int64(0)
+	for _, bm := range union {
+		if needed[bm.Filename] < 2 || !fetched[bm.Filename] {
+			continue
+		}
+		size, err := gosynth.BeatmapSize(ctx, bm, cacheDir)
+		if err != nil {
+			continue
+		}
+		saved += needed[bm.Filename] - 1
+		savedBytes += size * int64(needed[bm.Filename]-1)
+	}
+	if saved > 0 {
+		slog.Info(fmt.Sprintf("Sharing downloads between devices saved %d downloads (%s)", saved, gosynth.FormatBytes(savedBytes)))
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ninjaki8/GoSynth/gosynth"
+)
+
+// progressBarWidth is the number of cells in the progress bar
+const progressBarWidth = 30
+
+// syncProgress reports "X of N beatmaps synced" as each push completes. On a terminal it
+// keeps a progress bar on the console's status line; otherwise it logs one plain line
+// per beatmap.
+type syncProgress struct {
+	total int
+	done  int
+	// action and destination describe a finished beatmap, e.g. "Pushed" to "device at /sdcard/..."
+	action      string
+	destination string
+	console     *console
+	tty         bool
+	log         *slog.Logger
+	// start is a snapshot of the transfer statistics, to count this sync's bytes only
+	start gosynth.TransferStats
+}
+
+// newSyncProgress creates a progress reporter; tty enables the progress bar.
+func newSyncProgress(total int, action string, destination string, c *console, tty bool, log *slog.Logger) *syncProgress {
+	return &syncProgress{total: total, action: action, destination: destination, console: c, tty: tty, log: log, start: gosynth.Stats()}
+}
+
+// update records a finished beatmap and redraws the progress.
+func (p *syncProgress) update(result gosynth.BeatmapResult) {
+	p.done++
+
+	if result.Err != nil {
+		p.log.Error(fmt.Sprintf("Error processing %s: %v", result.Beatmap.Filename, result.Err))
+	} else {
+		p.log.Info(fmt.Sprintf("✅ %s %s to %s", p.action, result.Beatmap.Filename, p.destination))
+	}
+
+	status := fmt.Sprintf("%d of %d beatmaps synced (%d%%)", p.done, p.total, p.done*100/p.total)
+	// Sizes are learned as downloads start, so the total grows as the sync goes
+	stats := gosynth.Stats()
+	if started := stats.StartedBytes - p.start.StartedBytes; started > 0 {
+		status += fmt.Sprintf(", %s of %s downloaded",
+			gosynth.FormatBytes(stats.DownloadedBytes-p.start.DownloadedBytes), gosynth.FormatBytes(started))
+	}
+	if !p.tty {
+		p.log.Info(status)
+		return
+	}
+
+	filled := p.done * progressBarWidth / p.total
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled)
+	p.console.setStatus(fmt.Sprintf("[%s] %s", bar, status))
+}
+
+// finish removes the progress bar.
+func (p *syncProgress) finish() {
+	if p.tty {
+		p.console.clearStatus()
+	}
+}
+
+// pageProgress returns an onPage callback for gosynth.FetchAllPages that shows "Fetching
+// page X/Y" on the console's status line. Without a terminal it logs the same line every
+// tenth of the pages instead.
+func pageProgress(total int, c *console, tty bool) func(done int) {
+	step := max(total/10, 1)
+	return func(done int) {
+		status := fmt.Sprintf("Fetching page %d/%d", done, total)
+		switch {
+		case tty:
+			c.setStatus(status)
+		case done%step == 0 || done == total:
+			slog.Info(status)
+		}
+	}
+}
+
+// logTransferStats prints how long the catalog, the downloads and the pushes took and
+// how fast the downloads went. Download and push times add up across workers and devices.
+func logTransferStats(catalogTime time.Duration, total time.Duration) {
+	stats := gosynth.Stats()
+	download := fmt.Sprintf("%s in %v", gosynth.FormatBytes(stats.DownloadedBytes), stats.DownloadTime.Round(100*time.Millisecond))
+	if seconds := stats.DownloadTime.Seconds(); seconds > 0 {
+		download += fmt.Sprintf(" (%s/s)", gosynth.FormatBytes(int64(float64(stats.DownloadedBytes)/seconds)))
+	}
+
+	slog.Info("Statistics:")
+	slog.Info(fmt.Sprintf("  Catalog:   %v", catalogTime.Round(100*time.Millisecond)))
+	slog.Info(fmt.Sprintf("  Downloads: %s", download))
+	slog.Info(fmt.Sprintf("  Pushes:    %v", stats.PushTime.Round(100*time.Millisecond)))
+	slog.Info(fmt.Sprintf("  Total:     %v", total.Round(100*time.Millisecond)))
+}
+
+// isTerminal reports whether f is an interactive terminal rather than a pipe or file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+
+	"github.com/ninjaki8/GoSynth/gosynth"
+)
+
+// pruneDevice deletes device beatmaps that are missing from the server catalog. It refuses
+// to run if some pages failed to load, since their beatmaps would look deleted. The folder
+// is listed again first, so nothing is deleted based on a stale listing; an error is only
+// returned if that fails.
+func pruneDevice(ctx context.Context, adb gosynth.ADBClient, serial string, cat *catalog, listing *deviceFiles, opts *options, log *slog.Logger) error {
+	if cat.incomplete {
+		log.Warn("skipping --prune because the server catalog is incomplete")
+		return nil
+	}
+	if opts.query.Search != "" || opts.query.Mapper != "" {
+		log.Warn("skipping --prune because --search/--mapper only list part of the catalog")
+		return nil
+	}
+	if opts.recursive {
+		log.Warn("skipping --prune because --recursive lists beatmaps in subfolders by name only")
+		return nil
+	}
+
+	files, err := listing.refresh(ctx)
+	if err != nil {
+		return err
+	}
+	// Files unpacked from an archive on the server aren't in the catalog under their own name
+	extra := slices.DeleteFunc(gosynth.FindExtra(cat.all, files), listing.extracted)
+	if len(extra) == 0 {
+		log.Info("No beatmaps to prune.")
+		return nil
+	}
+
+	log.Info(fmt.Sprintf("%d beatmaps on the device are no longer on the server:", len(extra)))
+	for _, file := range extra {
+		log.Info(fmt.Sprintf("  %s", file))
+	}
+	if opts.dryRun {
+		return nil
+	}
+
+	if !opts.yes && !confirm(fmt.Sprintf("Delete %d beatmaps from %s?", len(extra), serial)) {
+		log.Info("Prune cancelled.")
+		return nil
+	}
+
+	for _, file := range extra {
+		remotePath := path.Join(opts.remoteDir, file)
+		if err := gosynth.DeleteDeviceFile(ctx, adb, serial, remotePath); err != nil {
+			log.Error(err.Error())
+			continue
+		}
+		listing.remove(file)
+		log.Info(fmt.Sprintf("🗑️ Deleted %s", remotePath))
+	}
+	return nil
+}
+
+// definePruneFlags registers the flags of the prune command.
+func definePruneFlags(flags *flag.FlagSet, opts *options) {
+	defineDeviceFlags(flags, opts)
+	flags.BoolVar(&opts.allDevices, "all-devices", false, "prune every connected device instead of selecting one")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "only list the beatmaps that would be deleted")
+	flags.BoolVar(&opts.yes, "yes", false, "don't ask for confirmation")
+	flags.DurationVar(&opts.timeout, "timeout", gosynth.DefaultAPITimeout, "timeout for each API page request, e.g. 30s")
+	flags.IntVar(&opts.concurrency, "concurrency", 4, "number of pages fetched in parallel")
+	flags.IntVar(&opts.query.PageSize, "page-size", 0, fmt.Sprintf("beatmaps per API page, up to %d (0 = API default)", gosynth.MaxPageSize))
+	flags.Float64Var(&opts.rateLimit, "rate-limit", gosynth.DefaultRateLimit, "maximum requests per second to synthriderz.com (0 = unlimited)")
+	flags.BoolVar(&opts.quietPages, "quiet-pages", false, "don't show progress while fetching the catalog, only the summary")
+	defineServerFlags(flags, opts)
+	defineOutputFlags(flags, opts)
+}
+
+// runPrune implements `gosynth prune`: it deletes beatmaps that were taken down from the
+// server without syncing anything.
+func runPrune(ctx context.Context, opts *options, out *console) int {
+	if opts.timeout <= 0 {
+		slog.Error("--timeout must be a positive duration")
+		return exitFatal
+	}
+	if opts.concurrency < 1 {
+		slog.Error("--concurrency must be at least 1")
+		return exitFatal
+	}
+	if opts.rateLimit < 0 {
+		slog.Error("--rate-limit must not be negative")
+		return exitFatal
+	}
+	if opts.query.PageSize < 0 || opts.query.PageSize > gosynth.MaxPageSize {
+		slog.Error(fmt.Sprintf("--page-size must be between 0 and %d", gosynth.MaxPageSize))
+		return exitFatal
+	}
+	gosynth.SetAPITimeout(opts.timeout)
+	gosynth.SetRateLimit(opts.rateLimit)
+
+	adb, err := newADB(opts)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitFatal
+	}
+	serials, err := pickDevices(ctx, adb, opts)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitFatal
+	}
+
+	firstPage, err := gosynth.FetchPage(ctx, 1, opts.query)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error fetching beatmaps: %v", err))
+		return exitFatal
+	}
+	cat := fetchCatalog(ctx, firstPage, opts, out)
+
+	code := exitOK
+	for _, serial := range serials {
+		log := slog.Default()
+		if len(serials) > 1 {
+			log = slog.With("device", serial)
+		}
+
+		if err := gosynth.CheckDeviceFolder(ctx, adb, opts.remoteDir, serial); err != nil {
+			log.Error(fmt.Sprintf("%v; check --remote-dir", err))
+			code = exitFailures
+			continue
+		}
+		listing := newDeviceFiles(adb, serial, opts)
+		if err := pruneDevice(ctx, adb, serial, cat, listing, opts, log); err != nil {
+			log.Error(err.Error())
+			code = exitFailures
+		}
+	}
+
+	if ctx.Err() != nil {
+		return exitCancelled
+	}
+	return code
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+
+	"github.com/ninjaki8/GoSynth/gosynth"
+)
+
+// syncReport is the machine-readable summary of one device printed by --json.
+type syncReport struct {
+	Serial      string       `json:"serial"`
+	OutputDir   string       `json:"output_dir,omitempty"`
+	ServerTotal int          `json:"server_total"`
+	DeviceCount int          `json:"device_count"`
+	Present     int          `json:"present"`
+	Missing     []string     `json:"missing"`
+	Results     []pushResult `json:"results"`
+	Skipped     []string     `json:"skipped,omitempty"`
+	UpToDate    bool         `json:"up_to_date,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// pushResult is the outcome of pushing one beatmap.
+type pushResult struct {
+	Filename string `json:"filename"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error,omitempty"`
+	TimedOut bool   `json:"timed_out,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// addResult records the outcome of a single beatmap, replacing any earlier outcome of
+// the same beatmap (e.g. when it's retried).
+func (r *syncReport) addResult(result gosynth.BeatmapResult) {
+	pr := pushResult{Filename: result.Beatmap.Filename, Success: result.Err == nil}
+	if result.Err != nil {
+		pr.Error = result.Err.Error()
+		pr.TimedOut = errors.Is(result.Err, gosynth.ErrTimedOut)
+		pr.Reason = failureReason(result.Err)
+	}
+
+	for i := range r.Results {
+		if r.Results[i].Filename == pr.Filename {
+			r.Results[i] = pr
+			return
+		}
+	}
+	r.Results = append(r.Results, pr)
+}
+
+// succeeded counts the beatmaps that were pushed.
+func (r *syncReport) succeeded() int {
+	n := 0
+	for _, result := range r.Results {
+		if result.Success {
+			n++
+		}
+	}
+	return n
+}
+
+// timedOut counts the beatmaps that failed because they hit --per-map-timeout.
+func (r *syncReport) timedOut() int {
+	n := 0
+	for _, result := range r.Results {
+		if result.TimedOut {
+			n++
+		}
+	}
+	return n
+}
+
+// failed counts the beatmaps that couldn't be pushed.
+func (r *syncReport) failed() int {
+	return len(r.Results) - r.succeeded()
+}
+
+// failureReason sorts an error into a short category for the summary.
+func failureReason(err error) string {
+	var statusErr *gosynth.StatusError
+	switch {
+	case errors.Is(err, gosynth.ErrTimedOut):
+		return "timed out"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "download timed out"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	case errors.As(err, &statusErr):
+		return "server answered " + statusErr.Status
+	case errors.Is(err, gosynth.ErrNotBeatmap):
+		return "not a beatmap"
+	case errors.Is(err, gosynth.ErrPushFailed):
+		return "push failed"
+	case errors.Is(err, gosynth.ErrNoSpace):
+		return "device full"
+	case errors.Is(err, gosynth.ErrDeviceNotFound):
+		return "device disconnected"
+	}
+	return "other errors"
+}
+
+// logSummary prints the outcome of a sync grouped by status, followed by the beatmaps
+// that failed so they are easy to retry. Nothing is printed if nothing was attempted.
+func logSummary(reports []*syncReport) {
+	synced, present, skipped := 0, 0, 0
+	reasons := make(map[string]int)
+	var failed []string
+	for _, report := range reports {
+		synced += report.succeeded()
+		present += report.Present
+		skipped += len(report.Skipped)
+		for _, result := range report.Results {
+			if !result.Success {
+				reasons[result.Reason]++
+				failed = append(failed, result.Filename)
+			}
+		}
+	}
+	// Keep the up-to-date case quiet
+	if synced+skipped+len(failed) == 0 {
+		return
+	}
+
+	slog.Info("Summary:")
+	slog.Info(fmt.Sprintf("  Synced:          %d", synced))
+	slog.Info(fmt.Sprintf("  Already present: %d", present))
+	if skipped > 0 {
+		slog.Info(fmt.Sprintf("  Skipped:         %d (over --max-download-size)", skipped))
+	}
+	slog.Info(fmt.Sprintf("  Failed:          %d", len(failed)))
+	var names []string
+	for reason := range reasons {
+		names = append(names, reason)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return reasons[names[i]] > reasons[names[j]] || (reasons[names[i]] == reasons[names[j]] && names[i] < names[j])
+	})
+	for _, reason := range names {
+		slog.Info(fmt.Sprintf("    %d %s", reasons[reason], reason))
+	}
+	slog.Info(fmt.Sprintf("  Downloaded:      %s", gosynth.FormatBytes(gosynth.Stats().DownloadedBytes)))
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		slog.Info("Failed beatmaps:")
+		for _, name := range failed {
+			slog.Info(fmt.Sprintf("  %s", name))
+		}
+	}
+}
+
+// writeJSON prints v as indented JSON on stdout.
+func writeJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
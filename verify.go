@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+
+	"github.com/ninjaki8/GoSynth/gosynth"
+)
+
+// defineVerifyFlags registers the flags of the verify command.
+func defineVerifyFlags(flags *flag.FlagSet, opts *options) {
+	defineDeviceFlags(flags, opts)
+	flags.BoolVar(&opts.yes, "yes", false, "re-push broken beatmaps without asking")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "only list the broken beatmaps")
+	flags.DurationVar(&opts.timeout, "timeout", gosynth.DefaultAPITimeout, "timeout for each API page request, e.g. 30s")
+	flags.DurationVar(&opts.downloadTimeout, "download-timeout", gosynth.DefaultDownloadTimeout, "maximum time for a single beatmap download when re-pushing")
+	flags.IntVar(&opts.concurrency, "concurrency", 4, "number of parallel page fetches, size lookups and downloads")
+	flags.IntVar(&opts.query.PageSize, "page-size", 0, fmt.Sprintf("beatmaps per API page, up to %d (0 = API default)", gosynth.MaxPageSize))
+	flags.Float64Var(&opts.rateLimit, "rate-limit", gosynth.DefaultRateLimit, "maximum requests per second to synthriderz.com (0 = unlimited)")
+	flags.BoolVar(&opts.quietPages, "quiet-pages", false, "don't show progress while fetching the catalog, only the summary")
+	defineServerFlags(flags, opts)
+	defineOutputFlags(flags, opts)
+}
+
+// runVerify implements `gosynth verify`: it compares the size of every beatmap on the
+// device with the size the server reports, lists the ones that differ and offers to
+// push them again.
+func runVerify(ctx context.Context, opts *options, out *console) int {
+	if opts.timeout <= 0 || opts.downloadTimeout <= 0 {
+		slog.Error("--timeout and --download-timeout must be positive durations")
+		return exitFatal
+	}
+	if opts.concurrency < 1 {
+		slog.Error("--concurrency must be at least 1")
+		return exitFatal
+	}
+	if opts.rateLimit < 0 {
+		slog.Error("--rate-limit must not be negative")
+		return exitFatal
+	}
+	if opts.query.PageSize < 0 || opts.query.PageSize > gosynth.MaxPageSize {
+		slog.Error(fmt.Sprintf("--page-size must be between 0 and %d", gosynth.MaxPageSize))
+		return exitFatal
+	}
+	gosynth.SetAPITimeout(opts.timeout)
+	gosynth.SetRateLimit(opts.rateLimit)
+
+	adb, err := newADB(opts)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitFatal
+	}
+	serials, err := pickDevices(ctx, adb, opts)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitFatal
+	}
+	serial := serials[0]
+
+	if err := gosynth.CheckDeviceFolder(ctx, adb, opts.remoteDir, serial); err != nil {
+		slog.Error(fmt.Sprintf("%v; check --remote-dir", err))
+		return exitFatal
+	}
+	files, err := gosynth.ListDeviceSongs(ctx, adb, opts.remoteDir, serial)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitFatal
+	}
+
+	firstPage, err := gosynth.FetchPage(ctx, 1, opts.query)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error fetching beatmaps: %v", err))
+		return exitFatal
+	}
+	cat := fetchCatalog(ctx, firstPage, opts, out)
+
+	// Compare the sizes of the beatmaps the device and the server both have
+	present := gosynth.FindPresent(cat.all, files)
+	slog.Info(fmt.Sprintf("Checking %d beatmaps on %s", len(present), serial))
+	serverSizes := gosynth.EstimateSizes(ctx, present, "", opts.concurrency)
+
+	var broken []gosynth.Beatmap
+	unknown := 0
+	for i, bm := range present {
+		if ctx.Err() != nil {
+			return exitCancelled
+		}
+		if serverSizes[i] < 0 {
+			unknown++
+			continue
+		}
+		remotePath := path.Join(opts.remoteDir, bm.Filename)
+		size, err := gosynth.DeviceFileSize(ctx, adb, serial, remotePath)
+		if err != nil {
+			slog.Warn(err.Error())
+			unknown++
+			continue
+		}
+		if size != serverSizes[i] {
+			slog.Info(fmt.Sprintf("  %s: %s on the device, %s on the server", bm.Filename,
+				gosynth.FormatBytes(size), gosynth.FormatBytes(serverSizes[i])))
+			broken = append(broken, bm)
+		}
+	}
+	if unknown > 0 {
+		slog.Warn(fmt.Sprintf("couldn't compare %d beatmaps", unknown))
+	}
+	if len(broken) == 0 {
+		slog.Info("All beatmaps on the device match the server.")
+		return exitOK
+	}
+	slog.Info(fmt.Sprintf("%d beatmaps on the device differ from the server", len(broken)))
+
+	if opts.dryRun {
+		return exitFailures
+	}
+	if !opts.yes && (!isTerminal(os.Stdin) || !confirm(fmt.Sprintf("Push %d beatmaps to %s again?", len(broken), serial))) {
+		return exitFailures
+	}
+
+	// Download fresh copies, since a cached one may be what's broken
+	progress := newSyncProgress(len(broken), "Re-pushed", "device at "+opts.remoteDir, out, !opts.quiet && out.tty, slog.Default())
+	_, failed := gosynth.Sync(ctx, broken, gosynth.SyncOptions{
+		ADB:             adb,
+		Serial:          serial,
+		RemoteDir:       opts.remoteDir,
+		Workers:         opts.concurrency,
+		DownloadTimeout: opts.downloadTimeout,
+		OnResult:        progress.update,
+	})
+	progress.finish()
+
+	switch {
+	case ctx.Err() != nil:
+		return exitCancelled
+	case failed > 0:
+		return exitFailures
+	}
+	return exitOK
+}